@@ -0,0 +1,27 @@
+#!/usr/bin/env python3
+
+"""
+Replays spp_monitor over the hours records was down, writing the prices ERCOT published
+for that time to the service's sinks with their historical timestamps.
+
+the same can be done from the command line:
+    RECORDS_CONFIG=config.json records backfill -service spp_monitor -from 2022-12-14T06:00:00-06:00 -to 2022-12-14T12:00:00-06:00
+"""
+import json
+import requests as r
+
+# use your url and key here
+uri = "http://127.0.0.1:9990/app/service/backfill"
+key = "your-40-character-key"
+
+q = {
+        "name": "spp_monitor",
+        # times are RFC3339, at most 31 days at once
+        "from": "2022-12-14T06:00:00-06:00",
+        "to": "2022-12-14T12:00:00-06:00"
+    }
+
+res = r.post(uri, headers={"Authorization": f"Bearer {key}"}, data=json.dumps(q))
+parsed_res = json.loads(res.text)
+nicer_res = json.dumps(parsed_res, indent=4)
+print(nicer_res)
@@ -0,0 +1,39 @@
+#!/usr/bin/env python3
+
+"""
+A records plugin. records starts it once and asks it for records on every tick of the
+services configured to use it, restarting it if it dies:
+
+    {
+        "name": "load_monitor",
+        "runtime": 3600,
+        "refresh": 5,
+        "worker": {
+            "type": "plugin",
+            "command": "/opt/records/plugin.py",
+            "params": {"zone": "houston"}
+        }
+    }
+
+requests come in on stdin and responses go out on stdout, one json object per line. the
+id of a response has to match its request. stderr shows up in /app/runtime/plugins.
+"""
+import json
+import random
+import sys
+
+
+def collect(service, params):
+    zone = params.get("zone", "all")
+    return [{"zone": zone, "load": round(random.uniform(40, 70), 2)}]
+
+
+for line in sys.stdin:
+    req = json.loads(line)
+    res = {"id": req["id"]}
+    try:
+        res["records"] = collect(req["service"], req.get("params") or {})
+    except Exception as e:
+        res["error"] = str(e)
+    # flush, or records waits on a buffer that never fills
+    print(json.dumps(res), flush=True)
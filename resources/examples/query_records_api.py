@@ -0,0 +1,31 @@
+#!/usr/bin/env python3
+
+"""
+The same prices query_zinc_api.py fetches from zinc, served straight from records
+by a service using the sqlite sink.
+
+the api key is whatever records placed in your KEY_STORE on startup.
+"""
+import json
+import requests as r
+from datetime import datetime as dt, timedelta
+
+# use your url and key here
+uri = "http://127.0.0.1:9990/app/records/query"
+key = "your-40-character-key"
+
+q = {
+        "service": "spp_monitor",
+        "index": "ErcotSPP",
+        # the last day, times are RFC3339
+        "from": (dt.now().astimezone() - timedelta(days=1)).isoformat(),
+        "where": [
+            {"field": "LzHouston", "op": ">", "value": 200}
+        ],
+        "limit": 10
+    }
+
+res = r.post(uri, headers={"Authorization": f"Bearer {key}"}, data=json.dumps(q))
+parsed_res = json.loads(res.text)
+nicer_res = json.dumps(parsed_res, indent=4)
+print(nicer_res)
@@ -1,13 +1,43 @@
 package definitions
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
+	"sync"
 	"time"
 )
 
 type ZincRecordV2 struct {
 	Index   string                   `json:"index"`
 	Records []map[string]interface{} `json:"records"`
+	// Time is set on historical records, sinks file them under it rather than now
+	Time time.Time `json:"-"`
+}
+
+// TimestampField is where a historical record's time is kept in each of its records
+const TimestampField = "@timestamp"
+
+// At is when the record was collected: its Time, the time kept in its records if it was
+// read back from somewhere that only kept those, or else now
+func (r ZincRecordV2) At(now time.Time) time.Time {
+	if !r.Time.IsZero() {
+		return r.Time
+	}
+	if len(r.Records) > 0 {
+		if ts, ok := r.Records[0][TimestampField].(string); ok {
+			if t, err := time.Parse(time.RFC3339, ts); err == nil {
+				return t
+			}
+		}
+	}
+	return now
+}
+
+// Historical reports whether the record was collected for some time in the past
+func (r ZincRecordV2) Historical() bool {
+	return !r.At(time.Time{}).IsZero()
 }
 
 type WeatherResponse struct {
@@ -86,6 +116,116 @@ type SysConResponse struct {
 	DC_S                   float32   `json:"dc_s"`
 }
 
+// HostSample starts every host monitor record. interval is the seconds since the tick the
+// rates are against, zero on the first tick when there are no rates yet.
+type HostSample struct {
+	Subsystem string    `json:"subsystem"`
+	Host      string    `json:"host"`
+	Time      time.Time `json:"time"`
+	Interval  float64   `json:"interval"`
+}
+
+// HostCpu is the percent of time spent in each state since the last tick, or since boot
+// on the first one
+type HostCpu struct {
+	HostSample
+	Usage  float64         `json:"usage"`
+	User   float64         `json:"user"`
+	System float64         `json:"system"`
+	Iowait float64         `json:"iowait"`
+	Steal  float64         `json:"steal"`
+	Cpus   []*HostCpuUsage `json:"cpus"`
+}
+
+type HostCpuUsage struct {
+	Name  string  `json:"name"`
+	Usage float64 `json:"usage"`
+}
+
+// HostMemory is in bytes, used is what isn't available
+type HostMemory struct {
+	HostSample
+	Total       uint64  `json:"total"`
+	Free        uint64  `json:"free"`
+	Available   uint64  `json:"available"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"used_percent"`
+	Buffers     uint64  `json:"buffers"`
+	Cached      uint64  `json:"cached"`
+	SwapTotal   uint64  `json:"swap_total"`
+	SwapFree    uint64  `json:"swap_free"`
+	SwapUsed    uint64  `json:"swap_used"`
+}
+
+type HostLoad struct {
+	HostSample
+	Load1     float64 `json:"load1"`
+	Load5     float64 `json:"load5"`
+	Load15    float64 `json:"load15"`
+	Running   int     `json:"running"`
+	Processes int     `json:"processes"`
+}
+
+type HostNetwork struct {
+	HostSample
+	Interfaces []*HostInterface `json:"interfaces"`
+}
+
+// HostInterface has the interface's counters since boot and their rates since the last tick
+type HostInterface struct {
+	Name            string  `json:"name"`
+	RxBytes         uint64  `json:"rx_bytes"`
+	TxBytes         uint64  `json:"tx_bytes"`
+	RxPackets       uint64  `json:"rx_packets"`
+	TxPackets       uint64  `json:"tx_packets"`
+	RxErrors        uint64  `json:"rx_errors"`
+	TxErrors        uint64  `json:"tx_errors"`
+	RxDrops         uint64  `json:"rx_drops"`
+	TxDrops         uint64  `json:"tx_drops"`
+	RxBytesPerSec   float64 `json:"rx_bytes_per_sec"`
+	TxBytesPerSec   float64 `json:"tx_bytes_per_sec"`
+	RxPacketsPerSec float64 `json:"rx_packets_per_sec"`
+	TxPacketsPerSec float64 `json:"tx_packets_per_sec"`
+}
+
+type HostDisks struct {
+	HostSample
+	Devices []*HostDisk `json:"devices"`
+}
+
+// HostDisk has the device's counters since boot and their rates since the last tick, busy
+// is the percent of the interval it had io in flight
+type HostDisk struct {
+	Name             string  `json:"name"`
+	Reads            uint64  `json:"reads"`
+	Writes           uint64  `json:"writes"`
+	ReadBytes        uint64  `json:"read_bytes"`
+	WriteBytes       uint64  `json:"write_bytes"`
+	ReadsPerSec      float64 `json:"reads_per_sec"`
+	WritesPerSec     float64 `json:"writes_per_sec"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+	BusyPercent      float64 `json:"busy_percent"`
+}
+
+type HostMounts struct {
+	HostSample
+	Mounts []*HostMount `json:"mounts"`
+}
+
+// HostMount is a filesystem's usage in bytes, available is what an unprivileged user can
+// still write
+type HostMount struct {
+	Path        string  `json:"path"`
+	Device      string  `json:"device"`
+	FsType      string  `json:"fs_type"`
+	Total       uint64  `json:"total"`
+	Free        uint64  `json:"free"`
+	Available   uint64  `json:"available"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
 type JsonResponse struct {
 	Error   bool        `json:"error"`
 	Message string      `json:"message"`
@@ -96,29 +236,416 @@ type Counters struct {
 	Start        time.Time
 	StoreEmptied int
 	Iterations   int
+	Misfires     int
+	Overlaps     int
+	Skips        int
+	LastSkip     string
+	// time spent waiting on the concurrency limits for a turn to run
+	QueueWait    time.Duration
+	QueueWaitMax time.Duration
 	Signature    int
+	SinkErrors   map[string]int
+	SinkWrites   map[string]int
+	mtx          sync.Mutex
+}
+
+// SinkError counts a failed delivery to the named sink
+func (c *Counters) SinkError(name string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.SinkErrors == nil {
+		c.SinkErrors = make(map[string]int)
+	}
+	c.SinkErrors[name]++
+}
+
+// SinkWrite counts a successful delivery to the named sink
+func (c *Counters) SinkWrite(name string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.SinkWrites == nil {
+		c.SinkWrites = make(map[string]int)
+	}
+	c.SinkWrites[name]++
+}
+
+// Skip counts a run that was skipped and remembers why
+func (c *Counters) Skip(reason string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.Skips++
+	c.LastSkip = reason
+}
+
+// Started marks when the service last started its worker loop
+func (c *Counters) Started(t time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.Start = t
+}
+
+// Iteration counts a service rotating
+func (c *Counters) Iteration() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.Iterations++
+}
+
+// Misfire counts scheduled start times that were missed
+func (c *Counters) Misfire(n int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.Misfires += n
+}
+
+// Overlap counts a tick that found the worker still running
+func (c *Counters) Overlap() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.Overlaps++
+}
+
+// Waited adds the time a run waited for its turn
+func (c *Counters) Waited(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.QueueWait += d
+	if d > c.QueueWaitMax {
+		c.QueueWaitMax = d
+	}
+}
+
+// Snapshot returns a copy of the counters taken under the lock
+func (c *Counters) Snapshot() *Counters {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	out := &Counters{
+		Start:        c.Start,
+		StoreEmptied: c.StoreEmptied,
+		Iterations:   c.Iterations,
+		Misfires:     c.Misfires,
+		Overlaps:     c.Overlaps,
+		Skips:        c.Skips,
+		LastSkip:     c.LastSkip,
+		QueueWait:    c.QueueWait,
+		QueueWaitMax: c.QueueWaitMax,
+		Signature:    c.Signature,
+		SinkErrors:   make(map[string]int),
+		SinkWrites:   make(map[string]int),
+	}
+	for k, v := range c.SinkErrors {
+		out.SinkErrors[k] = v
+	}
+	for k, v := range c.SinkWrites {
+		out.SinkWrites[k] = v
+	}
+	return out
+}
+
+// SinkCounts returns copies of the per sink delivery and error counts
+func (c *Counters) SinkCounts() (map[string]int, map[string]int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	writes, errs := make(map[string]int), make(map[string]int)
+	for k, v := range c.SinkWrites {
+		writes[k] = v
+	}
+	for k, v := range c.SinkErrors {
+		errs[k] = v
+	}
+	return writes, errs
+}
+
+// MarshalJSON holds the lock so the sink counts aren't changed while being read
+func (c *Counters) MarshalJSON() ([]byte, error) {
+	type counters Counters
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return json.Marshal(&struct {
+		*counters
+	}{(*counters)(c)})
 }
+
 type Store struct {
 	Records  []*ZincRecordV2
 	Errors   []*error
 	Counters *Counters
+	mtx      sync.Mutex
+}
+
+// AddRecord appends to the store's records, workers running side by side may call it
+func (s *Store) AddRecord(record *ZincRecordV2) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.Records = append(s.Records, record)
+}
+
+// Contents returns copies of the store's records and errors
+func (s *Store) Contents() ([]*ZincRecordV2, []*error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]*ZincRecordV2(nil), s.Records...), append([]*error(nil), s.Errors...)
+}
+
+// Deliver counts a kept record as handed on to the sink, false when every record kept so
+// far was already delivered. once there are 200 and none are waiting the store is emptied
+func (s *Store) Deliver() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.Counters.mtx.Lock()
+	defer s.Counters.mtx.Unlock()
+	if s.Counters.Signature >= len(s.Records) {
+		return false
+	}
+	s.Counters.Signature++
+	if s.Counters.Signature == len(s.Records) && len(s.Records) > 199 {
+		s.Records = nil
+		s.Counters.StoreEmptied++
+		s.Counters.Signature = 0
+	}
+	return true
+}
+
+// AddError appends to the store's errors, it is safe to call from a sink's goroutines
+func (s *Store) AddError(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.Errors = append(s.Errors, &err)
 }
 
 type ServiceDetails struct {
-	Name      string            `json:"name"`
-	Index     string            `json:"index"`
-	Runtime   int               `json:"runtime"`
-	Refresh   int               `json:"refresh"`
-	ReRun     bool              `json:"rerun"`
-	Scheduled bool              `json:"scheduled"`
-	StartAt   []string          `json:"start_at"`
-	ServiceId string            `json:"id"`
-	Waiting   bool              `json:"-"`
-	Kill      chan interface{}  `json:"-"`
-	Stream    chan ZincRecordV2 `json:"-"`
-	InfoLog   *log.Logger       `json:"-"`
-	ErrorLog  *log.Logger       `json:"-"`
-	Store     *Store            `json:"-"`
-}
-
-type WorkerMap map[string]func(chan ZincRecordV2)
+	Name         string            `json:"name"`
+	Index        string            `json:"index"`
+	Runtime      int               `json:"runtime"`
+	Refresh      int               `json:"refresh"`
+	Timeout      int               `json:"timeout,omitempty"`
+	Mode         string            `json:"mode,omitempty"`
+	Align        bool              `json:"align,omitempty"`
+	Jitter       int               `json:"jitter,omitempty"`
+	Overlap      string            `json:"overlap,omitempty"`
+	DependsOn    []string          `json:"depends_on,omitempty"`
+	MaxAge       int               `json:"max_age,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+	Hosts        []string          `json:"hosts,omitempty"`
+	ReRun        bool              `json:"rerun"`
+	Scheduled    bool              `json:"scheduled"`
+	StartAt      []string          `json:"start_at"`
+	Cron         string            `json:"cron,omitempty"`
+	TimeZone     string            `json:"time_zone,omitempty"`
+	Windows      []*Window         `json:"windows,omitempty"`
+	Holidays     string            `json:"holidays,omitempty"`
+	Misfire      string            `json:"misfire,omitempty"`
+	MisfireGrace int               `json:"misfire_grace,omitempty"`
+	NextRun      *time.Time        `json:"next_run,omitempty"`
+	Sink         *SinkConfig       `json:"sink,omitempty"`
+	Sinks        []*SinkConfig     `json:"sinks,omitempty"`
+	Worker       *WorkerConfig     `json:"worker,omitempty"`
+	ServiceId    string            `json:"id"`
+	Waiting      bool              `json:"-"`
+	Kill         chan interface{}  `json:"-"`
+	Gate         *Gate             `json:"-"`
+	Stream       chan ZincRecordV2 `json:"-"`
+	InfoLog      *log.Logger       `json:"-"`
+	ErrorLog     *log.Logger       `json:"-"`
+	Store        *Store            `json:"-"`
+	Output       Sink              `json:"-"`
+	// records on their way to the output, it isn't closed until they land
+	Deliveries *sync.WaitGroup `json:"-"`
+	// guards NextRun, Waiting and ServiceId, the scheduler changes them while the api reads
+	Mtx *sync.Mutex `json:"-"`
+}
+
+// Window is a span of the day a service runs in, on days like "mon-fri". an end at or
+// before the start runs past midnight.
+type Window struct {
+	Days     string `json:"days"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	TimeZone string `json:"time_zone"`
+}
+
+// Gate holds back a paused service until it is resumed
+type Gate struct {
+	mtx    sync.Mutex
+	resume chan struct{}
+}
+
+// Pause closes the gate, it reports false if it was already closed
+func (g *Gate) Pause() bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.resume != nil {
+		return false
+	}
+	g.resume = make(chan struct{})
+	return true
+}
+
+// Resume opens the gate, it reports false if it was already open
+func (g *Gate) Resume() bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.resume == nil {
+		return false
+	}
+	close(g.resume)
+	g.resume = nil
+	return true
+}
+
+func (g *Gate) Paused() bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.resume != nil
+}
+
+// Open returns a channel that is closed once the gate is open, straight away if it is
+func (g *Gate) Open() <-chan struct{} {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.resume != nil {
+		return g.resume
+	}
+	open := make(chan struct{})
+	close(open)
+	return open
+}
+
+// Sink is a destination for collected records. Write may deliver immediately or hold
+// the record until Flush, Close flushes whatever is left and releases the sink.
+type Sink interface {
+	Write(record ZincRecordV2) error
+	Flush() error
+	Close() error
+}
+
+// SinkConfig selects and configures a sink a service delivers to. when a service has no
+// sink or sinks configured it goes to zinc at the runtime's zinc_uri.
+type SinkConfig struct {
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	Uri          string `json:"uri"`
+	User         string `json:"user"`
+	PasswordEnv  string `json:"password_env"`
+	Retries      int    `json:"retries"`
+	DisableSpool bool   `json:"disable_spool"`
+	BatchCount   int    `json:"batch_count"`
+	BatchBytes   int    `json:"batch_bytes"`
+	BatchAge     int    `json:"batch_age"`
+	// file
+	Path    string `json:"path"`
+	Format  string `json:"format"`
+	MaxSize int64  `json:"max_size"`
+	Gzip    bool   `json:"gzip"`
+	// webhook
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	Template  string            `json:"template"`
+	SecretEnv string            `json:"secret_env"`
+	// influx
+	Org         string   `json:"org"`
+	Bucket      string   `json:"bucket"`
+	TokenEnv    string   `json:"token_env"`
+	Measurement string   `json:"measurement"`
+	Tags        []string `json:"tags"`
+	Fields      []string `json:"fields"`
+	TimeField   string   `json:"time_field"`
+	Precision   string   `json:"precision"`
+}
+
+// WorkerConfig builds a service's worker from config, so a new source doesn't need code.
+// records are indexed under the service's name unless index is set.
+type WorkerConfig struct {
+	Type  string `json:"type"`
+	Index string `json:"index"`
+	// http_json, $VARS in headers, query and body come from the environment
+	Url     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Query   map[string]string `json:"query"`
+	Body    string            `json:"body"`
+	Records string            `json:"records"`
+	Rename  map[string]string `json:"rename"`
+	// html_table, rows is last (the default), all, or new, which needs a key to know
+	// what it has seen. header_row is 1 based, -1 for none, 0 takes a first row of <th>.
+	Table     string         `json:"table"`
+	HeaderRow int            `json:"header_row"`
+	Columns   []*TableColumn `json:"columns"`
+	Rows      string         `json:"rows"`
+	Key       string         `json:"key"`
+	TimeZone  string         `json:"time_zone"`
+	// exec and plugin, values in env can use $VARS from the runtime's environment
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+	Dir     string            `json:"dir"`
+	// plugin, sent along with every collect
+	Params map[string]interface{} `json:"params"`
+	// host_monitor, subsystems are cpu, memory, load, network, disk and mounts, all of them
+	// unless set. mounts are the mount points to report, every block device's by default.
+	ProcRoot   string   `json:"proc_root"`
+	Subsystems []string `json:"subsystems"`
+	Mounts     []string `json:"mounts"`
+}
+
+// TableColumn maps a column, found by its header or its 1 based position, to a field.
+// type is string (the default), float, int, bool or time, which is parsed with format.
+type TableColumn struct {
+	Header   string `json:"header"`
+	Position int    `json:"position"`
+	Field    string `json:"field"`
+	Type     string `json:"type"`
+	Format   string `json:"format"`
+}
+
+// Warning is an error that doesn't cost a worker its record, the record is kept and the
+// warning goes in the store's errors
+type Warning struct {
+	Err error
+}
+
+func (w *Warning) Error() string {
+	return w.Err.Error()
+}
+
+func (w *Warning) Unwrap() error {
+	return w.Err
+}
+
+// ErrNothingNew is returned by a worker that ran fine but had nothing new to report
+var ErrNothingNew = errors.New("nothing new")
+
+// Worker collects a record for a service. it should give up once ctx is done.
+type Worker interface {
+	Collect(ctx context.Context) (ZincRecordV2, error)
+}
+
+// WorkerFunc lets a plain function act as a Worker
+type WorkerFunc func(ctx context.Context) (ZincRecordV2, error)
+
+func (f WorkerFunc) Collect(ctx context.Context) (ZincRecordV2, error) {
+	return f(ctx)
+}
+
+// RangeWorker is a worker that can also collect the records for a past time range, which
+// is what a backfill needs. records come back with their Time set.
+type RangeWorker interface {
+	Worker
+	CollectRange(ctx context.Context, from, to time.Time) ([]ZincRecordV2, error)
+}
+
+// DerivedWorker is a worker that can build its record from the records of the services it
+// depends on, keyed by service name, instead of collecting them itself
+type DerivedWorker interface {
+	Worker
+	Derive(ctx context.Context, inputs map[string]ZincRecordV2) (ZincRecordV2, error)
+}
+
+// HostWorker is a worker that knows which hosts it talks to, so host_limits apply to it
+// without the service listing them
+type HostWorker interface {
+	Worker
+	Hosts() []string
+}
+
+type WorkerMap map[string]Worker
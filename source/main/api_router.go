@@ -10,6 +10,8 @@ import (
 func (app *Application) apiRoutes() http.Handler {
 	mux := chi.NewRouter()
 	mux.Use(middleware.Recoverer)
+	// prometheus scrapes this without a key
+	mux.Get("/metrics", app.Metrics)
 
 	mux.Route("/app", func(mux chi.Router) {
 		mux.Use(app.authenticate)
@@ -19,10 +21,16 @@ func (app *Application) apiRoutes() http.Handler {
 		mux.Get("/runtime/stats", app.ListAllCounters)
 		mux.Post("/runtime/kill", app.KillService)
 		mux.Post("/runtime/start", app.StartService)
+		mux.Post("/runtime/pause", app.PauseService)
+		mux.Post("/runtime/resume", app.ResumeService)
+		mux.Get("/runtime/plugins", app.ListPlugins)
 
 		mux.Post("/service/store", app.GetStore)
 		mux.Post("/service/runtime", app.GetRuntime)
 		mux.Post("/service/errors", app.GetErrorsById)
+		mux.Post("/service/backfill", app.Backfill)
+
+		mux.Post("/records/query", app.QueryRecords)
 	})
 	// might need static files later
 	// fserver := http.FileServer(http.Dir("./static/"))
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/sinks"
+)
+
+// the longest range a single backfill will replay
+const maxBackfill = 31 * 24 * time.Hour
+
+// backfillRequest asks for a service to be replayed over a past range, times are RFC3339
+type backfillRequest struct {
+	Name string    `json:"name"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// backfillResult is what a backfill wrote and what went wrong along the way
+type backfillResult struct {
+	Name    string    `json:"name"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Records int       `json:"records"`
+	Errors  []string  `json:"errors"`
+}
+
+// backfill collects a service's records for a past range and writes them, with their
+// historical times, to the sinks the service is configured with. the sinks are not spooled
+// so every failure makes it into the result.
+func (app *Application) backfill(ctx context.Context, req backfillRequest) (*backfillResult, error) {
+	if req.Name == "" {
+		return nil, errors.New("a backfill needs the name of a service")
+	}
+	if !req.From.Before(req.To) {
+		return nil, fmt.Errorf("from (%v) should be before to (%v)", req.From, req.To)
+	}
+	if req.To.Sub(req.From) > maxBackfill {
+		return nil, fmt.Errorf("can not backfill more than %v at once", maxBackfill)
+	}
+	if req.To.After(app.clock().Now()) {
+		return nil, errors.New("can not backfill the future")
+	}
+	s := &serviceDetails{Name: req.Name}
+	app.getDefaults(s)
+	wkr, err := app.workerFor(s)
+	if err != nil {
+		return nil, err
+	}
+	rw, ok := wkr.(definitions.RangeWorker)
+	if !ok {
+		return nil, fmt.Errorf("%v can not collect past records", req.Name)
+	}
+	var cfgs []*definitions.SinkConfig
+	for _, cfg := range append([]*definitions.SinkConfig{s.Sink}, s.Sinks...) {
+		if cfg == nil {
+			continue
+		}
+		c := *cfg
+		c.DisableSpool = true
+		cfgs = append(cfgs, &c)
+	}
+	if s.Sink == nil && len(s.Sinks) == 0 {
+		cfgs = append(cfgs, &definitions.SinkConfig{Type: "zinc", DisableSpool: true})
+	}
+
+	result := &backfillResult{Name: req.Name, From: req.From, To: req.To}
+	var mtx sync.Mutex
+	out, err := sinks.NewAll(cfgs, sinks.Options{
+		Service:    req.Name,
+		ZincUri:    app.Config.ZincUri,
+		DataDir:    app.Config.DataDir,
+		SqlitePath: app.sqlitePath(),
+		ErrorLog:   app.ErrorLog,
+		Report: func(sink string, err error) {
+			if err == nil {
+				return
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			result.Errors = append(result.Errors, fmt.Sprintf("%v: %v", sink, err))
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	app.InfoLog.Printf("backfilling %v from %v to %v", req.Name, req.From, req.To)
+	records, err := rw.CollectRange(ctx, req.From, req.To)
+	if err != nil {
+		// whatever was collected before the failure is still written
+		result.Errors = append(result.Errors, err.Error())
+	}
+	for _, record := range records {
+		// failures are reported by the sink
+		_ = out.Write(record)
+	}
+	if err := out.Close(); err != nil {
+		mtx.Lock()
+		result.Errors = append(result.Errors, err.Error())
+		mtx.Unlock()
+	}
+	result.Records = len(records)
+	app.InfoLog.Printf("backfilled %v records for %v with %v errors", result.Records, req.Name, len(result.Errors))
+	return result, nil
+}
+
+// backfillCommand runs `records backfill -service name -from time -to time` and returns
+// the exit code
+func (app *Application) backfillCommand(args []string) int {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	name := fs.String("service", "", "the service to replay")
+	from := fs.String("from", "", "start of the range, RFC3339")
+	to := fs.String("to", "", "end of the range, RFC3339, defaults to now")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	req := backfillRequest{Name: *name, To: app.clock().Now()}
+	var err error
+	if req.From, err = time.Parse(time.RFC3339, *from); err != nil {
+		fmt.Println("bad -from:", err)
+		return 2
+	}
+	if *to != "" {
+		if req.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			fmt.Println("bad -to:", err)
+			return 2
+		}
+	}
+	result, err := app.backfill(context.Background(), req)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("wrote %v records for %v\n", result.Records, result.Name)
+	for _, e := range result.Errors {
+		fmt.Println("error:", e)
+	}
+	if len(result.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// rangeWorker hands back one record per hour of whatever range it is asked for
+type rangeWorker struct {
+	definitions.WorkerFunc
+}
+
+func (rangeWorker) CollectRange(ctx context.Context, from, to time.Time) ([]definitions.ZincRecordV2, error) {
+	var out []definitions.ZincRecordV2
+	for at := from; at.Before(to); at = at.Add(time.Hour) {
+		out = append(out, definitions.ZincRecordV2{
+			Index:   "prices",
+			Records: []map[string]interface{}{{"price": 21.5, definitions.TimestampField: at.Format(time.RFC3339)}},
+			Time:    at,
+		})
+	}
+	return out, nil
+}
+
+func TestBackfill(t *testing.T) {
+	schedulerApp(t, time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC))
+	app.Config.Services = []*serviceDetails{
+		{Name: "spp_monitor", Sink: &definitions.SinkConfig{Type: "file"}},
+		{Name: "rtsc_monitor"},
+	}
+	app.Config.WorkerMap = &definitions.WorkerMap{
+		"spp_monitor":  rangeWorker{},
+		"rtsc_monitor": definitions.WorkerFunc(nil),
+	}
+
+	from := time.Date(2022, 12, 14, 22, 0, 0, 0, time.UTC)
+	result, err := app.backfill(context.Background(), backfillRequest{Name: "spp_monitor", From: from, To: from.Add(4 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Records != 4 || len(result.Errors) != 0 {
+		t.Fatalf("expected 4 records without errors, got %+v", result)
+	}
+	// the records are filed under the days they were collected for, not today
+	files, err := filepath.Glob(filepath.Join(app.Config.DataDir, "spp_monitor", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	if strings.Join(names, " ") != "prices-20221214-0.jsonl prices-20221215-0.jsonl" {
+		t.Fatalf("unexpected files %v", names)
+	}
+	body, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"@timestamp":"2022-12-14T22:00:00Z"`) {
+		t.Errorf("expected the historical timestamp in %s", body)
+	}
+
+	for _, req := range []backfillRequest{
+		{Name: "rtsc_monitor", From: from, To: from.Add(time.Hour)},
+		{Name: "nope", From: from, To: from.Add(time.Hour)},
+		{Name: "spp_monitor", From: from, To: from},
+		{Name: "spp_monitor", From: from, To: from.Add(90 * 24 * time.Hour)},
+		{Name: "spp_monitor", From: from, To: time.Date(2022, 12, 21, 0, 0, 0, 0, time.UTC)},
+	} {
+		if _, err := app.backfill(context.Background(), req); err == nil {
+			t.Errorf("expected %+v to be refused", req)
+		}
+	}
+}
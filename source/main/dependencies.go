@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// result is the outcome of a service's latest run
+type result struct {
+	Record definitions.ZincRecordV2
+	Err    error
+	At     time.Time
+}
+
+// resultHub keeps every service's latest result and wakes the services depending on it
+type resultHub struct {
+	mtx    sync.Mutex
+	latest map[string]*result
+	subs   map[string][]chan struct{}
+}
+
+func newResultHub() *resultHub {
+	return &resultHub{
+		latest: make(map[string]*result),
+		subs:   make(map[string][]chan struct{}),
+	}
+}
+
+// publish records a service's result and nudges anyone subscribed to it
+func (h *resultHub) publish(name string, r *result) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.latest[name] = r
+	for _, c := range h.subs[name] {
+		// a nudge already waiting covers this one too
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel nudged whenever any of the named services publishes
+func (h *resultHub) subscribe(names []string) chan struct{} {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	c := make(chan struct{}, 1)
+	for _, name := range names {
+		h.subs[name] = append(h.subs[name], c)
+	}
+	return c
+}
+
+func (h *resultHub) unsubscribe(c chan struct{}) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for name, subs := range h.subs {
+		for i, sub := range subs {
+			if sub == c {
+				h.subs[name] = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (h *resultHub) get(name string) (*result, bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	r, ok := h.latest[name]
+	return r, ok
+}
+
+// runDerived waits on the services s depends on and builds a record from their output
+// each time all of them have produced something new, until s is killed. a run is skipped,
+// with the reason kept in the counters and errors, if an input failed or is too old.
+func (s *serviceDetails) runDerived(wkr definitions.Worker) {
+	dw, ok := wkr.(definitions.DerivedWorker)
+	if !ok {
+		s.ErrorLog.Printf("%v depends on %v but its worker can't use their records", s.Name, s.DependsOn)
+		return
+	}
+	nudge := app.Results.subscribe(s.DependsOn)
+	defer app.Results.unsubscribe(nudge)
+	clock := app.clock()
+	s.Store.Counters.Started(clock.Now())
+	s.InfoLog.Printf("%v (%v) is waiting on %v", s.ServiceId, s.Name, strings.Join(s.DependsOn, ", "))
+	var last time.Time
+	for {
+		select {
+		case <-s.Kill:
+			return
+		case <-nudge:
+		}
+		if s.Gate.Paused() {
+			continue
+		}
+		inputs, ready, reason := s.inputs(last)
+		if !ready {
+			continue
+		}
+		last = clock.Now()
+		if reason != "" {
+			s.Store.Counters.Skip(reason)
+			s.Store.AddError(fmt.Errorf("%v skipped: %v", s.Name, reason))
+			s.InfoLog.Printf("%v skipped: %v", s.Name, reason)
+			continue
+		}
+		s.run(definitions.WorkerFunc(func(ctx context.Context) (definitions.ZincRecordV2, error) {
+			return dw.Derive(ctx, inputs)
+		}))
+	}
+}
+
+// inputs gathers the latest record of every dependency. it is not ready until each of
+// them has a result newer than since, and gives a reason to skip if any of those failed
+// or are older than max_age.
+func (s *serviceDetails) inputs(since time.Time) (map[string]definitions.ZincRecordV2, bool, string) {
+	inputs := make(map[string]definitions.ZincRecordV2)
+	var reasons []string
+	for _, name := range s.DependsOn {
+		r, ok := app.Results.get(name)
+		if !ok || !r.At.After(since) {
+			return nil, false, ""
+		}
+		switch {
+		case r.Err != nil:
+			reasons = append(reasons, fmt.Sprintf("%v failed: %v", name, r.Err))
+		case s.MaxAge > 0 && app.clock().Since(r.At) > time.Duration(s.MaxAge)*time.Second:
+			reasons = append(reasons, fmt.Sprintf("%v is older than %vs", name, s.MaxAge))
+		default:
+			inputs[name] = r.Record
+		}
+	}
+	return inputs, true, strings.Join(reasons, "; ")
+}
+
+// dependencyErrors checks the depends_on of every service, each must name another
+// configured service and following them must never lead back around
+func dependencyErrors(services []*serviceDetails) map[string]error {
+	deps := make(map[string][]string)
+	for _, s := range services {
+		deps[s.Name] = s.DependsOn
+	}
+	errs := make(map[string]error)
+	for _, s := range services {
+		for _, d := range s.DependsOn {
+			if _, ok := deps[d]; !ok {
+				errs[s.Name] = fmt.Errorf("%v depends on %v, which is not a configured service", s.Name, d)
+			}
+		}
+	}
+	// a depth first walk, a service met again while still on the path closes a cycle
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var path []string
+	var walk func(name string)
+	walk = func(name string) {
+		switch state[name] {
+		case done:
+			return
+		case visiting:
+			for i, p := range path {
+				if p == name {
+					cycle := append(append([]string{}, path[i:]...), name)
+					for _, c := range path[i:] {
+						errs[c] = fmt.Errorf("%v is part of a dependency cycle: %v", c, strings.Join(cycle, " -> "))
+					}
+				}
+			}
+			return
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, d := range deps[name] {
+			walk(d)
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+	}
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		walk(name)
+	}
+	return errs
+}
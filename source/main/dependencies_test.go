@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+func Test_dependencyErrors(t *testing.T) {
+	errs := dependencyErrors([]*serviceDetails{
+		{Name: "spp"},
+		{Name: "weather"},
+		{Name: "power", DependsOn: []string{"spp", "weather"}},
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"c"}},
+		{Name: "c", DependsOn: []string{"a"}},
+		{Name: "lost", DependsOn: []string{"nowhere"}},
+	})
+	for _, name := range []string{"spp", "weather", "power"} {
+		if err, ok := errs[name]; ok {
+			t.Errorf("unexpected error for %v: %v", name, err)
+		}
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := errs[name]; err == nil || !strings.Contains(err.Error(), "cycle") {
+			t.Errorf("expected %v to be in a cycle, got %v", name, err)
+		}
+	}
+	if err := errs["lost"]; err == nil || !strings.Contains(err.Error(), "nowhere") {
+		t.Errorf("expected lost to have an unknown dependency, got %v", err)
+	}
+}
+
+// joiner hands the inputs it is given over to the test
+type joiner struct {
+	definitions.WorkerFunc
+	derived chan map[string]definitions.ZincRecordV2
+}
+
+func (j joiner) Derive(ctx context.Context, inputs map[string]definitions.ZincRecordV2) (definitions.ZincRecordV2, error) {
+	j.derived <- inputs
+	return definitions.ZincRecordV2{Index: "joined"}, nil
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("condition never met")
+}
+
+func TestRunDerived(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "power", Refresh: 10, DependsOn: []string{"spp", "weather"}, MaxAge: 60}
+	s.Sink = &definitions.SinkConfig{Type: "file", DisableSpool: true}
+	if err := app.initService(s); err != nil {
+		t.Fatal(err)
+	}
+	wkr := joiner{derived: make(chan map[string]definitions.ZincRecordV2, 1)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(wkr)
+	}()
+	publish := func(name string, err error, at time.Time) {
+		app.Results.publish(name, &result{Record: definitions.ZincRecordV2{Index: name}, Err: err, At: at})
+	}
+	eventually(t, func() bool {
+		app.Results.mtx.Lock()
+		defer app.Results.mtx.Unlock()
+		return len(app.Results.subs["weather"]) == 1
+	})
+
+	// nothing happens until every input has something
+	publish("spp", nil, clock.Now())
+	publish("weather", nil, clock.Now())
+	select {
+	case inputs := <-wkr.derived:
+		if len(inputs) != 2 || inputs["spp"].Index != "spp" || inputs["weather"].Index != "weather" {
+			t.Fatalf("unexpected inputs %v", inputs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("never derived")
+	}
+
+	// a failed input skips the run
+	clock.Advance(time.Second)
+	publish("spp", errors.New("ercot is down"), clock.Now())
+	publish("weather", nil, clock.Now())
+	eventually(t, func() bool { return strings.Contains(counterSkip(s), "spp failed: ercot is down") })
+
+	// and so does one older than max_age
+	clock.Advance(10 * time.Minute)
+	publish("spp", nil, clock.Now().Add(-5*time.Minute))
+	publish("weather", nil, clock.Now())
+	eventually(t, func() bool { return strings.Contains(counterSkip(s), "spp is older than 60s") })
+
+	close(s.Kill)
+	waitDone(t, done)
+	select {
+	case inputs := <-wkr.derived:
+		t.Errorf("expected skipped runs not to derive, got %v", inputs)
+	default:
+	}
+}
+
+// counterSkip reads the last skip reason under the counters' lock
+func counterSkip(s *serviceDetails) string {
+	out, _ := s.Store.Counters.MarshalJSON()
+	return string(out)
+}
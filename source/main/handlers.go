@@ -1,11 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
+	"os"
+	"strings"
 
 	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/services"
+	"github.com/rexlx/records/source/sinks"
 )
 
 type jsonResponse definitions.JsonResponse
@@ -13,9 +17,31 @@ type service struct {
 	Id string `json:"id"`
 }
 
-// ListServices lists all running services
+// runningService is a service in the runtime listing
+type runningService struct {
+	Id    string `json:"id"`
+	State string `json:"state"`
+}
+
+// ListServices lists all running services by name with their id and whether they are
+// running, waiting on a schedule or paused
 func (app *Application) ListServices(w http.ResponseWriter, r *http.Request) {
-	_ = app.writeJSON(w, http.StatusOK, app.ServiceRegistry)
+	app.Mtx.RLock()
+	out := make(map[string]runningService)
+	for name, uid := range app.ServiceRegistry {
+		rs := runningService{Id: uid}
+		if s, ok := app.StateMap[uid]; ok {
+			rs.State = s.state()
+		}
+		out[name] = rs
+	}
+	app.Mtx.RUnlock()
+	_ = app.writeJSON(w, http.StatusOK, out)
+}
+
+// ListPlugins shows the health of every plugin process
+func (app *Application) ListPlugins(w http.ResponseWriter, r *http.Request) {
+	_ = app.writeJSON(w, http.StatusOK, services.Plugins())
 }
 
 func (app *Application) ListAllCounters(w http.ResponseWriter, r *http.Request) {
@@ -39,7 +65,7 @@ func (app *Application) GetRuntime(w http.ResponseWriter, r *http.Request) {
 		_ = app.writeJSON(w, http.StatusBadRequest, data)
 	}
 	if _, ok := app.StateMap[sid.Id]; ok {
-		rt := time.Since(app.StateMap[sid.Id].Store.Counters.Start).Minutes()
+		rt := app.clock().Since(app.StateMap[sid.Id].Store.Counters.Snapshot().Start).Minutes()
 		msg := jsonResponse{
 			Error: false,
 			Data:  rt,
@@ -65,9 +91,10 @@ func (app *Application) GetErrorsById(w http.ResponseWriter, r *http.Request) {
 		_ = app.writeJSON(w, http.StatusBadRequest, data)
 	}
 	if _, ok := app.StateMap[sid.Id]; ok {
+		_, errs := app.StateMap[sid.Id].Store.Contents()
 		msg := jsonResponse{
 			Error: false,
-			Data:  app.StateMap[sid.Id].Store.Errors,
+			Data:  errs,
 		}
 		_ = app.writeJSON(w, http.StatusOK, msg)
 	} else {
@@ -109,6 +136,42 @@ func (app *Application) KillService(w http.ResponseWriter, r *http.Request) {
 	_ = app.writeJSON(w, http.StatusOK, msg)
 }
 
+// PauseService stops a service from ticking, it keeps its id, counters, store and schedule
+func (app *Application) PauseService(w http.ResponseWriter, r *http.Request) {
+	app.gateService(w, r, "paused", (*definitions.Gate).Pause)
+}
+
+// ResumeService lets a paused service carry on
+func (app *Application) ResumeService(w http.ResponseWriter, r *http.Request) {
+	app.gateService(w, r, "resumed", (*definitions.Gate).Resume)
+}
+
+func (app *Application) gateService(w http.ResponseWriter, r *http.Request, verb string, change func(*definitions.Gate) bool) {
+	var sid service
+	err := app.readJSON(w, r, &sid)
+	if err != nil {
+		_ = app.errorJSON(w, errors.New("invalid json"))
+		return
+	}
+	app.Mtx.RLock()
+	s, ok := app.StateMap[sid.Id]
+	app.Mtx.RUnlock()
+	if !ok {
+		_ = app.errorJSON(w, errors.New("id does not exist"))
+		return
+	}
+	if !change(s.Gate) {
+		_ = app.errorJSON(w, fmt.Errorf("%v is already %v", sid.Id, verb))
+		return
+	}
+	app.InfoLog.Println(s.Name, sid.Id, verb)
+	msg := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%v %v", verb, sid.Id),
+	}
+	_ = app.writeJSON(w, http.StatusOK, msg)
+}
+
 func (app *Application) GetStore(w http.ResponseWriter, r *http.Request) {
 	var sid service
 	var data jsonResponse
@@ -150,23 +213,28 @@ func (app *Application) StartService(w http.ResponseWriter, r *http.Request) {
 
 	if _, ok := app.ServiceRegistry[sid.Name]; !ok {
 		newService := serviceDetails{
-			Name:     sid.Name,
-			InfoLog:  app.InfoLog,
-			ErrorLog: app.ErrorLog,
-			Store:    &definitions.Store{},
-			Kill:     make(chan interface{}),
+			Name: sid.Name,
 		}
 		app.getDefaults(&newService)
-
-		if _, ok := (*app.Config.WorkerMap)[newService.Name]; ok {
-			wkr := (*app.Config.WorkerMap)[newService.Name]
-			go newService.Run(wkr)
-			msg := jsonResponse{
-				Error:   false,
-				Message: fmt.Sprintf("started the following service %v", sid.Name),
-			}
-			_ = app.writeJSON(w, http.StatusOK, msg)
+		if err, ok := dependencyErrors(app.Config.Services)[newService.Name]; ok {
+			_ = app.errorJSON(w, err)
+			return
+		}
+		wkr, err := app.workerFor(&newService)
+		if err != nil {
+			_ = app.errorJSON(w, err)
+			return
+		}
+		if err := app.initService(&newService); err != nil {
+			_ = app.errorJSON(w, err)
+			return
+		}
+		go newService.Run(wkr)
+		msg := jsonResponse{
+			Error:   false,
+			Message: fmt.Sprintf("started the following service %v", sid.Name),
 		}
+		_ = app.writeJSON(w, http.StatusOK, msg)
 	} else {
 		msg := jsonResponse{
 			Error:   true,
@@ -175,3 +243,69 @@ func (app *Application) StartService(w http.ResponseWriter, r *http.Request) {
 		_ = app.writeJSON(w, http.StatusOK, msg)
 	}
 }
+
+// QueryRecords searches the records stored by sqlite sinks
+func (app *Application) QueryRecords(w http.ResponseWriter, r *http.Request) {
+	var q sinks.Query
+	err := app.readJSON(w, r, &q)
+	if err != nil {
+		_ = app.errorJSON(w, errors.New("invalid json"))
+		return
+	}
+	// opening a database that isn't there would create an empty one
+	if _, err := os.Stat(app.sqlitePath()); errors.Is(err, os.ErrNotExist) {
+		_ = app.errorJSON(w, errors.New("no records are stored, configure a sqlite sink first"), http.StatusNotFound)
+		return
+	}
+	db, err := sinks.OpenDB(app.sqlitePath())
+	if err != nil {
+		app.ErrorLog.Println(err)
+		_ = app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	results, err := sinks.QueryRecords(db, q)
+	if err != nil {
+		_ = app.errorJSON(w, err)
+		return
+	}
+	msg := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("found %v records", len(results)),
+		Data:    results,
+	}
+	_ = app.writeJSON(w, http.StatusOK, msg)
+}
+
+// Backfill replays a service over a past range, writing what it collects to its sinks
+func (app *Application) Backfill(w http.ResponseWriter, r *http.Request) {
+	var req backfillRequest
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		_ = app.errorJSON(w, errors.New("invalid json"))
+		return
+	}
+	result, err := app.backfill(r.Context(), req)
+	if err != nil {
+		_ = app.errorJSON(w, err)
+		return
+	}
+	msg := jsonResponse{
+		Error:   len(result.Errors) > 0,
+		Message: fmt.Sprintf("wrote %v records for %v with %v errors", result.Records, result.Name, len(result.Errors)),
+		Data:    result,
+	}
+	_ = app.writeJSON(w, http.StatusOK, msg)
+}
+
+// Metrics exposes the latest collected values and the service counters to prometheus
+func (app *Application) Metrics(w http.ResponseWriter, r *http.Request) {
+	var out strings.Builder
+	app.Gauges.write(&out)
+	app.writeCounterMetrics(&out)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(out.String()))
+	if err != nil {
+		app.ErrorLog.Println(err)
+	}
+}
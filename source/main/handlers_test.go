@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/sinks"
+)
+
+func TestQueryRecords(t *testing.T) {
+	schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	query := func() (int, jsonResponse) {
+		rr := httptest.NewRecorder()
+		app.QueryRecords(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"service": "spp_monitor"}`)))
+		var res jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return rr.Code, res
+	}
+
+	// without a sqlite sink there is nothing to query, and nothing is created
+	if code, res := query(); code != http.StatusNotFound || !res.Error {
+		t.Errorf("expected a missing database to be not found, got %v %+v", code, res)
+	}
+	if _, err := os.Stat(app.sqlitePath()); !os.IsNotExist(err) {
+		t.Errorf("expected no database to be created, got %v", err)
+	}
+
+	db, err := sinks.NewSQLiteSink(app.sqlitePath(), sinks.Options{Service: "spp_monitor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Write(definitions.ZincRecordV2{Index: "ErcotSPP", Records: []map[string]interface{}{{"LzHouston": 150.0}}}); err != nil {
+		t.Fatal(err)
+	}
+	if code, res := query(); code != http.StatusOK || res.Message != "found 1 records" {
+		t.Errorf("expected the stored record, got %v %+v", code, res)
+	}
+}
@@ -12,9 +12,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/rexlx/records/source/definitions"
-	"github.com/rexlx/records/source/services"
+	"github.com/rexlx/records/source/schedule"
+	"github.com/rexlx/records/source/sinks"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -28,10 +30,10 @@ func (app *Application) registerService(uid string, state *serviceDetails) {
 	app.StateMap[uid] = state
 }
 
-// removeService removes a services from the application state map
+// removeService removes a services from the application state map and closes its sink
 func (app *Application) removeService(uid string) {
 	app.Mtx.Lock()
-	defer app.Mtx.Unlock()
+	s, ok := app.StateMap[uid]
 	delete(app.StateMap, uid)
 	// also remove from registry, this may change in the future idk
 	for k, v := range app.ServiceRegistry {
@@ -39,6 +41,29 @@ func (app *Application) removeService(uid string) {
 			delete(app.ServiceRegistry, k)
 		}
 	}
+	app.Mtx.Unlock()
+	if ok && s.Output != nil {
+		// nothing new can be handed to the sink now, let what already was land
+		s.Deliveries.Wait()
+		if err := s.Output.Close(); err != nil {
+			app.ErrorLog.Println(err, uid)
+		}
+	}
+}
+
+// closeSinks flushes and closes the sink of every running service
+func (app *Application) closeSinks() {
+	app.Mtx.Lock()
+	defer app.Mtx.Unlock()
+	for uid, s := range app.StateMap {
+		if s.Output == nil {
+			continue
+		}
+		s.Deliveries.Wait()
+		if err := s.Output.Close(); err != nil {
+			app.ErrorLog.Println(err, uid)
+		}
+	}
 }
 
 // getAllServiceData returns an unordered list of service state maps
@@ -55,7 +80,8 @@ func (app *Application) getAllServiceData() []*serviceDetails {
 // getStore returns a list of records if they exist or an error
 func (app *Application) getStore(uid string) ([]*definitions.ZincRecordV2, error) {
 	if _, ok := app.StateMap[uid]; ok {
-		return app.StateMap[uid].Store.Records, nil
+		records, _ := app.StateMap[uid].Store.Contents()
+		return records, nil
 	}
 	return []*definitions.ZincRecordV2{}, errors.New("invalid id")
 }
@@ -71,15 +97,17 @@ func (app *Application) getServiceDataById(uid string) (*serviceDetails, error)
 // getAllServiceCounters returns a list of all counters premarshalled into bytes
 func (app *Application) getAllServiceCounters() []byte {
 	type statContainer struct {
-		Name     string                `json:"name"`
-		Counters *definitions.Counters `json:"counters"`
+		Name       string                `json:"name"`
+		Counters   *definitions.Counters `json:"counters"`
+		SpoolDepth int                   `json:"spool_depth"`
 	}
 
 	var stats []*statContainer
 	for _, svc := range app.StateMap {
 		s := &statContainer{
-			Name:     svc.Name,
-			Counters: svc.Store.Counters,
+			Name:       svc.Name,
+			Counters:   svc.Store.Counters,
+			SpoolDepth: sinks.Depth(svc.Output),
 		}
 		stats = append(stats, s)
 	}
@@ -93,7 +121,11 @@ func (app *Application) getAllServiceCounters() []byte {
 
 // getLoadedServices returns a list of services premarshalled into bytes
 func (app *Application) getLoadedServices() []byte {
-	out, err := json.Marshal(app.Config.Services)
+	svs := make([]serviceDetails, len(app.Config.Services))
+	for i, s := range app.Config.Services {
+		svs[i] = s.copy()
+	}
+	out, err := json.Marshal(svs)
 	if err != nil {
 		app.InfoLog.Println(err)
 	}
@@ -107,12 +139,66 @@ func (app *Application) getDefaults(s *serviceDetails) {
 		if i.Name == s.Name {
 			s.Runtime = i.Runtime
 			s.Refresh = i.Refresh
+			s.Timeout = i.Timeout
+			s.Mode = i.Mode
+			s.Align = i.Align
+			s.Jitter = i.Jitter
+			s.Overlap = i.Overlap
+			s.DependsOn = i.DependsOn
+			s.MaxAge = i.MaxAge
+			s.Priority = i.Priority
+			s.Hosts = i.Hosts
 			s.ReRun = i.ReRun
 			s.StartAt = i.StartAt
+			s.Cron = i.Cron
+			s.TimeZone = i.TimeZone
+			s.Windows = i.Windows
+			s.Holidays = i.Holidays
+			s.Misfire = i.Misfire
+			s.MisfireGrace = i.MisfireGrace
+			s.Sink = i.Sink
+			s.Sinks = i.Sinks
+			s.Worker = i.Worker
 		}
 	}
 }
 
+// initService gives a service its logs, an empty store and the sink it delivers to
+func (app *Application) initService(s *serviceDetails) error {
+	s.InfoLog = app.InfoLog
+	s.ErrorLog = app.ErrorLog
+	s.Store = &definitions.Store{}
+	s.Store.Counters = &definitions.Counters{}
+	s.Kill = make(chan interface{})
+	s.Gate = &definitions.Gate{}
+	s.Deliveries = &sync.WaitGroup{}
+	s.Mtx = &sync.Mutex{}
+	cfgs := s.Sinks
+	if s.Sink != nil {
+		cfgs = append([]*definitions.SinkConfig{s.Sink}, cfgs...)
+	}
+	out, err := sinks.NewAll(cfgs, sinks.Options{
+		Service:    s.Name,
+		ZincUri:    app.Config.ZincUri,
+		DataDir:    app.Config.DataDir,
+		SqlitePath: app.sqlitePath(),
+		ErrorLog:   app.ErrorLog,
+		Report: func(sink string, err error) {
+			if err == nil {
+				s.Store.Counters.SinkWrite(sink)
+				return
+			}
+			s.Store.AddError(fmt.Errorf("%v: %w", sink, err))
+			s.Store.Counters.SinkError(sink)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	s.Output = out
+	return nil
+}
+
 // nameApplication fetches an adjective-noun style random name from an api and sets
 // the apps ID to that (and thus, the key file name in the bucket)
 func (app *Application) nameApplication() {
@@ -148,46 +234,38 @@ func (app *Application) nameApplication() {
 	app.Id = pl.Data
 }
 
-// handleStore sends the records to be indexed (look into zinclabs).
-func (app *Application) handleStore(uid string, store *definitions.Store) {
-	if len(store.Records) == app.StateMap[uid].Store.Counters.Signature {
+// handleStore sends a record the service kept to its sink.
+func (app *Application) handleStore(uid string, record definitions.ZincRecordV2) {
+	app.Mtx.RLock()
+	s, ok := app.StateMap[uid]
+	if ok {
+		s.Deliveries.Add(1)
+	}
+	app.Mtx.RUnlock()
+	if !ok {
+		// the service finished before its last record got here
+		return
+	}
+	defer s.Deliveries.Done()
+	if !s.Store.Deliver() {
 		err := errors.New("service progressed, but state was unchanged")
 		app.ErrorLog.Println(err, uid)
-		app.StateMap[uid].Store.Errors = append(app.StateMap[uid].Store.Errors, &err)
+		s.Store.AddError(err)
 		return
 	}
-	if len(store.Records) > app.StateMap[uid].Store.Counters.Signature {
-		app.StateMap[uid].Store.Counters.Signature = len(store.Records)
-		services.SaveRecordToZinc(app.Config.ZincUri, *store.Records[len(store.Records)-1], app.ErrorLog)
-	}
-	if len(store.Records) > 199 {
-		var emptyStore []*definitions.ZincRecordV2
-		// saveStore slated for removal
-		// app.saveStore(uid, store)
-		app.StateMap[uid].Store.Counters.StoreEmptied += 1
-		app.StateMap[uid].Store.Counters.Signature = 0
-		store.Records = emptyStore
+	app.Gauges.observe(s.Name, record)
+	if err := s.Output.Write(record); err != nil {
+		app.ErrorLog.Println(err, uid)
 	}
-	// this needs a sync rw mutex i bet
-	app.StateMap[uid].Store = store
 }
 
-// saves slice to disk. this was for initial testing, slated for removal
-func (app *Application) saveStore(uid string, store *definitions.Store) {
-	err := app.handleServiceStorageDir(uid)
-	if err != nil {
-		app.ErrorLog.Println(err)
-	}
-	outfile := fmt.Sprintf("%v/%v/data.json", app.Config.DataDir, uid)
-
-	file, err := os.OpenFile(outfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		app.ErrorLog.Println("couldnt save the store", err)
-		return
+// sqlitePath is where the sqlite sink and the query endpoint find their database,
+// records.db in the data dir unless sqlite_path is set
+func (app *Application) sqlitePath() string {
+	if app.Config.Sqlite != "" {
+		return app.Config.Sqlite
 	}
-	defer file.Close()
-	enc := json.NewEncoder(file)
-	enc.Encode(store.Records)
+	return filepath.Join(app.Config.DataDir, "records.db")
 }
 
 // SanitizeServiceName replaces white space with underscores
@@ -195,16 +273,6 @@ func SanitizeServiceName(name string) string {
 	return strings.ReplaceAll(name, " ", "_")
 }
 
-// handleServiceStorageDir creates a directory if it doesnt exist.
-func (app *Application) handleServiceStorageDir(uid string) error {
-	dasPath := filepath.Join(app.Config.DataDir, uid)
-	err := os.MkdirAll(dasPath, os.ModePerm)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 // errorJSON writes an error message to the responseWriter
 func (app *Application) errorJSON(w http.ResponseWriter, err error, status ...int) error {
 	statusCode := http.StatusBadRequest
@@ -367,8 +435,49 @@ func genRandomString() (string, error) {
 
 // serviceValidator ensures a configured service meets whatever evolving criteria may...evolve
 func serviceValidator(s *serviceDetails) error {
-	if s.Runtime < 1 || s.Refresh < 1 {
+	// windowed and derived services run for as long as their windows or inputs say
+	if s.Refresh < 1 || (s.Runtime < 1 && len(s.Windows) == 0 && len(s.DependsOn) == 0) {
 		return fmt.Errorf("wont start service: %v. runtime or refresh set to zero in config", s.Name)
 	}
+	if s.Timeout < 0 {
+		return fmt.Errorf("wont start service: %v. timeout can not be negative", s.Name)
+	}
+	switch s.Mode {
+	case "", modeDelay, modeFixed:
+	default:
+		return fmt.Errorf("wont start service: %v. unknown mode %q, expected %v or %v", s.Name, s.Mode, modeDelay, modeFixed)
+	}
+	switch s.Overlap {
+	case "", overlapSkip, overlapQueue, overlapConcurrent:
+	default:
+		return fmt.Errorf("wont start service: %v. unknown overlap %q, expected %v, %v or %v", s.Name, s.Overlap, overlapSkip, overlapQueue, overlapConcurrent)
+	}
+	for _, d := range s.DependsOn {
+		if d == s.Name {
+			return fmt.Errorf("wont start service: %v. it depends on itself", s.Name)
+		}
+	}
+	if s.MaxAge < 0 {
+		return fmt.Errorf("wont start service: %v. max_age can not be negative", s.Name)
+	}
+	if _, err := s.location(); err != nil {
+		return fmt.Errorf("wont start service: %v. bad time_zone: %w", s.Name, err)
+	}
+	if s.Jitter < 0 || s.Jitter >= s.Refresh {
+		return fmt.Errorf("wont start service: %v. jitter should be less than refresh", s.Name)
+	}
+	if len(s.Windows) > 0 {
+		if _, err := s.calendar(); err != nil {
+			return fmt.Errorf("wont start service: %v. bad windows: %w", s.Name, err)
+		}
+	}
+	if s.Scheduled {
+		if _, err := s.schedule(); err != nil {
+			return fmt.Errorf("wont start service: %v. bad schedule: %w", s.Name, err)
+		}
+		if err := schedule.ValidMisfire(s.Misfire); err != nil {
+			return fmt.Errorf("wont start service: %v. %w", s.Name, err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// limiter bounds how many workers run at once across every service, and how many of
+// them talk to the same host. waiting workers are let in highest priority first, one held
+// back by a host limit doesn't hold back others bound elsewhere.
+type limiter struct {
+	mtx         sync.Mutex
+	max         int
+	running     int
+	hostMax     map[string]int
+	hostRunning map[string]int
+	queue       []*ticket
+	seq         uint64
+}
+
+type ticket struct {
+	priority int
+	seq      uint64
+	limits   []string
+	granted  chan struct{}
+}
+
+// newLimiter returns nil, which lets everything through, when there is nothing to limit
+func newLimiter(max int, hostLimits map[string]int) *limiter {
+	if max < 1 && len(hostLimits) == 0 {
+		return nil
+	}
+	l := &limiter{
+		max:         max,
+		hostMax:     make(map[string]int),
+		hostRunning: make(map[string]int),
+	}
+	for host, n := range hostLimits {
+		// a limit below one would hold the host's workers back forever
+		if n > 0 {
+			l.hostMax[strings.ToLower(host)] = n
+		}
+	}
+	return l
+}
+
+// acquire waits for a turn to run a worker that talks to hosts. it returns a func to give
+// the turn back, or false if stop closed first.
+func (l *limiter) acquire(priority int, hosts []string, stop <-chan interface{}) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	l.mtx.Lock()
+	l.seq++
+	t := &ticket{priority: priority, seq: l.seq, limits: l.limitsFor(hosts), granted: make(chan struct{})}
+	l.queue = append(l.queue, t)
+	sort.SliceStable(l.queue, func(i, j int) bool {
+		if l.queue[i].priority != l.queue[j].priority {
+			return l.queue[i].priority > l.queue[j].priority
+		}
+		return l.queue[i].seq < l.queue[j].seq
+	})
+	l.dispatch()
+	l.mtx.Unlock()
+
+	select {
+	case <-t.granted:
+		return func() { l.release(t) }, true
+	case <-stop:
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		for i, q := range l.queue {
+			if q == t {
+				l.queue = append(l.queue[:i], l.queue[i+1:]...)
+				return nil, false
+			}
+		}
+		// granted just as we stopped
+		l.free(t)
+		l.dispatch()
+		return nil, false
+	}
+}
+
+func (l *limiter) release(t *ticket) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.free(t)
+	l.dispatch()
+}
+
+func (l *limiter) free(t *ticket) {
+	l.running--
+	for _, h := range t.limits {
+		l.hostRunning[h]--
+	}
+}
+
+// dispatch grants every queued ticket that fits, in queue order
+func (l *limiter) dispatch() {
+	remaining := l.queue[:0]
+	for _, t := range l.queue {
+		if !l.fits(t) {
+			remaining = append(remaining, t)
+			continue
+		}
+		l.running++
+		for _, h := range t.limits {
+			l.hostRunning[h]++
+		}
+		close(t.granted)
+	}
+	l.queue = remaining
+}
+
+func (l *limiter) fits(t *ticket) bool {
+	if l.max > 0 && l.running >= l.max {
+		return false
+	}
+	for _, h := range t.limits {
+		if l.hostRunning[h] >= l.hostMax[h] {
+			return false
+		}
+	}
+	return true
+}
+
+// limitsFor finds the host limits that apply, a limit on ercot.com covers www.ercot.com
+func (l *limiter) limitsFor(hosts []string) []string {
+	seen := make(map[string]bool)
+	var limits []string
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		for limit := range l.hostMax {
+			if (host == limit || strings.HasSuffix(host, "."+limit)) && !seen[limit] {
+				seen[limit] = true
+				limits = append(limits, limit)
+			}
+		}
+	}
+	return limits
+}
+
+// waiting is how many workers are queued
+func (l *limiter) waiting() int {
+	if l == nil {
+		return 0
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return len(l.queue)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/services"
+)
+
+// acquireAsync queues for a turn in the background and sends the release func once granted
+func acquireAsync(l *limiter, priority int, hosts []string, stop chan interface{}) chan func() {
+	granted := make(chan func(), 1)
+	go func() {
+		if release, ok := l.acquire(priority, hosts, stop); ok {
+			granted <- release
+		}
+	}()
+	return granted
+}
+
+// queued waits for the limiter to hold n workers back
+func queued(t *testing.T, l *limiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for l.waiting() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v waiting, got %v", n, l.waiting())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterPriority(t *testing.T) {
+	l := newLimiter(1, nil)
+	release, ok := l.acquire(0, nil, nil)
+	if !ok {
+		t.Fatal("expected the first turn right away")
+	}
+	low := acquireAsync(l, 0, nil, nil)
+	queued(t, l, 1)
+	high := acquireAsync(l, 10, nil, nil)
+	queued(t, l, 2)
+
+	release()
+	select {
+	case next := <-high:
+		next()
+	case <-low:
+		t.Fatal("expected the higher priority worker to go first")
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was let in")
+	}
+	select {
+	case next := <-low:
+		next()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lower priority worker was never let in")
+	}
+}
+
+func TestLimiterHosts(t *testing.T) {
+	l := newLimiter(0, map[string]int{"ERCOT.com": 1})
+	release, ok := l.acquire(0, []string{"www.ercot.com"}, nil)
+	if !ok {
+		t.Fatal("expected the first turn right away")
+	}
+	blocked := acquireAsync(l, 0, []string{"ercot.com"}, nil)
+	queued(t, l, 1)
+	// a worker bound elsewhere isn't held back behind it
+	if other, ok := l.acquire(0, []string{"api.weather.gov", "notercot.com"}, nil); !ok {
+		t.Fatal("expected a different host to go through")
+	} else {
+		other()
+	}
+	select {
+	case <-blocked:
+		t.Fatal("expected the host limit to hold the second ercot worker")
+	default:
+	}
+	release()
+	select {
+	case next := <-blocked:
+		next()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second ercot worker was never let in")
+	}
+}
+
+func TestLimiterWorkerHosts(t *testing.T) {
+	schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	app.Config.WorkerMap = &definitions.WorkerMap{"rtsc_monitor": services.RtscWorker{}, "spp_monitor": services.SppWorker{}}
+	l := newLimiter(0, map[string]int{"ercot.com": 1})
+	// neither lists its hosts, the compiled in workers know they are on ercot.com
+	rtsc, spp := &serviceDetails{Name: "rtsc_monitor"}, &serviceDetails{Name: "spp_monitor"}
+	for _, s := range []*serviceDetails{rtsc, spp} {
+		if _, err := app.workerFor(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release, ok := l.acquire(0, rtsc.Hosts, nil)
+	if !ok {
+		t.Fatal("expected the first turn right away")
+	}
+	blocked := acquireAsync(l, 0, spp.Hosts, nil)
+	queued(t, l, 1)
+	release()
+	select {
+	case next := <-blocked:
+		next()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second ercot worker was never let in")
+	}
+
+	// hosts the service does list are left alone
+	s := &serviceDetails{Name: "spp_monitor", Hosts: []string{"mirror.example.com"}}
+	if _, err := app.workerFor(s); err != nil || len(s.Hosts) != 1 || s.Hosts[0] != "mirror.example.com" {
+		t.Errorf("expected the listed hosts to be kept, got %v and %v", s.Hosts, err)
+	}
+}
+
+func TestLimiterStop(t *testing.T) {
+	l := newLimiter(1, nil)
+	release, _ := l.acquire(0, nil, nil)
+	stop := make(chan interface{})
+	done := make(chan bool)
+	go func() {
+		_, ok := l.acquire(0, nil, stop)
+		done <- ok
+	}()
+	queued(t, l, 1)
+	close(stop)
+	if <-done {
+		t.Error("expected a stopped worker to give up its place")
+	}
+	if l.waiting() != 0 {
+		t.Errorf("expected the queue to be empty, got %v", l.waiting())
+	}
+	release()
+	// the stopped worker didn't take the slot with it
+	if next, ok := l.acquire(0, nil, nil); !ok {
+		t.Error("expected the slot to be free")
+	} else {
+		next()
+	}
+}
+
+func TestLimiterNil(t *testing.T) {
+	l := newLimiter(0, nil)
+	if l != nil {
+		t.Fatal("expected no limiter when there is nothing to limit")
+	}
+	release, ok := l.acquire(0, []string{"ercot.com"}, nil)
+	if !ok {
+		t.Fatal("expected a nil limiter to let everything through")
+	}
+	release()
+}
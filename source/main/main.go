@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
 	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/schedule"
 	"github.com/rexlx/records/source/services"
 )
 
@@ -21,17 +25,25 @@ type Application struct {
 	Id              string
 	ServiceRegistry map[string]string
 	StateMap        map[string]*serviceDetails
+	Gauges          *metricsRegistry
+	Clock           schedule.Clock
+	Results         *resultHub
+	Limiter         *limiter
 	Mtx             sync.RWMutex
 }
 
 // configuration specific to this runtime
 type RuntimeConfig struct {
-	ZincUri   string                 `json:"zinc_uri"`
-	LogPath   string                 `json:"logpath"`
-	DataDir   string                 `json:"data_dir"`
-	Port      int                    `json:"api_port"`
-	Services  []*serviceDetails      `json:"services"`
-	WorkerMap *definitions.WorkerMap `json:"-"`
+	ZincUri string `json:"zinc_uri"`
+	LogPath string `json:"logpath"`
+	DataDir string `json:"data_dir"`
+	Sqlite  string `json:"sqlite_path"`
+	Port    int    `json:"api_port"`
+	// how many workers may run at once, and at once against a host, zero is no limit
+	MaxConcurrency int                    `json:"max_concurrency"`
+	HostLimits     map[string]int         `json:"host_limits"`
+	Services       []*serviceDetails      `json:"services"`
+	WorkerMap      *definitions.WorkerMap `json:"-"`
 }
 
 func main() {
@@ -68,23 +80,51 @@ func main() {
 		InfoLog:         infoLog,
 		ErrorLog:        errorLog,
 		StateMap:        state,
+		Gauges:          newMetricsRegistry(),
+		Results:         newResultHub(),
+		Limiter:         newLimiter(config.MaxConcurrency, config.HostLimits),
 		Mtx:             sync.RWMutex{},
 	}
 	app.nameApplication()
 	// this is how we pass the instance of this application to the scheduler
 	AppReceiver(&app)
+	hostMonitor, err := services.NewHostWorker("hostMonRxlx", &definitions.WorkerConfig{})
+	if err != nil {
+		log.Fatalln(err)
+	}
 	// this is where we define our service to function map...for now
-	app.startServcies(definitions.WorkerMap{
-		"weather_monitor": services.GetWeather,
-		"rtsc_monitor":    services.GetRealTimeSysCon,
-		"spp_monitor":     services.GetSPP,
-		"cpu_monitor":     services.CpuMon,
-	})
+	workers := definitions.WorkerMap{
+		"weather_monitor": definitions.WorkerFunc(services.GetWeather),
+		"rtsc_monitor":    services.RtscWorker{},
+		"spp_monitor":     services.SppWorker{},
+		"cpu_monitor":     definitions.WorkerFunc(services.CpuMon),
+		"power_monitor":   services.PowerWorker{},
+		"host_monitor":    hostMonitor,
+	}
+	// `records backfill ...` replays a service and exits instead of starting the runtime
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		app.Config.WorkerMap = &workers
+		os.Exit(app.backfillCommand(os.Args[2:]))
+	}
+	app.startServcies(workers)
+	// pending batches get flushed if we are asked to stop
+	go app.handleSignals()
 	// start the api and listen
 	app.startApi()
 
 }
 
+// handleSignals waits for an interrupt or terminate, closes every sink and exits
+func (app *Application) handleSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	s := <-sig
+	app.InfoLog.Printf("received %v, flushing sinks", s)
+	app.closeSinks()
+	services.StopPlugins()
+	os.Exit(0)
+}
+
 // startApi starts an http server
 func (app *Application) startApi() error {
 	app.InfoLog.Printf("starting api on port %v", app.Config.Port)
@@ -96,18 +136,50 @@ func (app *Application) startApi() error {
 	return srv.ListenAndServe()
 }
 
+// workerFor is the worker a service runs, built from its worker config or else the one
+// compiled in under its name
+func (app *Application) workerFor(s *serviceDetails) (definitions.Worker, error) {
+	if s.Worker != nil {
+		wkr, err := services.NewWorker(s.Name, s.Worker)
+		if err != nil {
+			return nil, err
+		}
+		// so host_limits apply without listing the host twice
+		if u, err := url.Parse(s.Worker.Url); err == nil && u.Hostname() != "" && len(s.Hosts) == 0 {
+			s.Hosts = []string{u.Hostname()}
+		}
+		return wkr, nil
+	}
+	if app.Config.WorkerMap != nil {
+		if wkr, ok := (*app.Config.WorkerMap)[s.Name]; ok {
+			if hw, ok := wkr.(definitions.HostWorker); ok && len(s.Hosts) == 0 {
+				s.Hosts = hw.Hosts()
+			}
+			return wkr, nil
+		}
+	}
+	return nil, fmt.Errorf("no worker for %v", s.Name)
+}
+
 // startServices loops over the workerMap and starts the processes in the background,
 // registering it to the application in the process.
 func (app *Application) startServcies(svs definitions.WorkerMap) {
 	app.Config.WorkerMap = &svs
+	depErrs := dependencyErrors(app.Config.Services)
 	for _, i := range app.Config.Services {
-		i.InfoLog = app.InfoLog
-		i.ErrorLog = app.ErrorLog
-		i.Store = &definitions.Store{}
-		i.Store.Counters = &definitions.Counters{}
-		i.Kill = make(chan interface{})
-		if _, ok := svs[i.Name]; ok {
-			go i.Run(svs[i.Name])
+		if err, ok := depErrs[i.Name]; ok {
+			app.ErrorLog.Println(err)
+			continue
+		}
+		wkr, err := app.workerFor(i)
+		if err != nil {
+			app.ErrorLog.Println(err)
+			continue
+		}
+		if err := app.initService(i); err != nil {
+			app.ErrorLog.Println(err)
+			continue
 		}
+		go i.Run(wkr)
 	}
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/sinks"
+)
+
+// metricsRegistry keeps the latest value of every numeric field each service collected
+type metricsRegistry struct {
+	mtx    sync.RWMutex
+	latest map[string]map[metricKey]float64
+}
+
+// metricKey identifies a single gauge, item names the record within a batch and is left
+// empty when a service only collects one record at a time
+type metricKey struct {
+	name  string
+	index string
+	item  string
+}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// itemFields are the flattened fields that tell the records of a batch apart, the first one
+// a record has names it. workers fill batches in whatever order their results come back, so
+// the position of a record says nothing about which city, subsystem or core it is
+var itemFields = []string{"location.name", "subsystem", "Name"}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{latest: make(map[string]map[metricKey]float64)}
+}
+
+// observe replaces whatever was last seen for the service and index with the record's values
+func (m *metricsRegistry) observe(service string, record definitions.ZincRecordV2) {
+	values := make(map[metricKey]float64)
+	for _, r := range record.Records {
+		flat := make(map[string]interface{})
+		sinks.Flatten("", r, flat)
+		var item string
+		if len(record.Records) > 1 {
+			if item = itemOf(flat); item == "" {
+				// without a name its series would change meaning from one batch to the next
+				continue
+			}
+		}
+		for field, val := range flat {
+			var f float64
+			switch v := val.(type) {
+			case float64:
+				f = v
+			case int:
+				f = float64(v)
+			case bool:
+				if v {
+					f = 1
+				}
+			default:
+				continue
+			}
+			values[metricKey{name: metricName(field), index: record.Index, item: item}] = f
+		}
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.latest[service] == nil {
+		m.latest[service] = make(map[metricKey]float64)
+	}
+	for key := range m.latest[service] {
+		if key.index == record.Index {
+			delete(m.latest[service], key)
+		}
+	}
+	for key, val := range values {
+		m.latest[service][key] = val
+	}
+}
+
+// write renders every gauge in the prometheus text format, grouped by metric name
+func (m *metricsRegistry) write(w *strings.Builder) {
+	type series struct {
+		labels string
+		value  float64
+	}
+	m.mtx.RLock()
+	families := make(map[string][]series)
+	for service, values := range m.latest {
+		for key, val := range values {
+			labels := []string{label("service", service), label("index", key.index)}
+			if key.item != "" {
+				labels = append(labels, label("item", key.item))
+			}
+			families[key.name] = append(families[key.name], series{strings.Join(labels, ","), val})
+		}
+	}
+	m.mtx.RUnlock()
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %v gauge\n", name)
+		sort.Slice(families[name], func(i, j int) bool { return families[name][i].labels < families[name][j].labels })
+		for _, s := range families[name] {
+			fmt.Fprintf(w, "%v{%v} %v\n", name, s.labels, s.value)
+		}
+	}
+}
+
+// itemOf returns the value of the first item field the flattened record has
+func itemOf(flat map[string]interface{}) string {
+	for _, field := range itemFields {
+		if v, ok := flat[field].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// metricName turns a record field into a valid prometheus metric name
+func metricName(field string) string {
+	return "records_" + invalidMetricChars.ReplaceAllString(field, "_")
+}
+
+func label(name, value string) string {
+	return fmt.Sprintf(`%v="%v"`, name, strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+}
+
+// writeCounterMetrics renders the counters of every running service
+func (app *Application) writeCounterMetrics(w *strings.Builder) {
+	counters := []struct {
+		name, kind, help string
+		value            func(*serviceDetails, *definitions.Counters) float64
+	}{
+		{"records_service_iterations_total", "counter", "times the service has rotated", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return float64(c.Iterations)
+		}},
+		{"records_service_misfires_total", "counter", "scheduled start times that were missed", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return float64(c.Misfires)
+		}},
+		{"records_service_overlaps_total", "counter", "fixed rate ticks skipped or held back by a worker still running", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return float64(c.Overlaps)
+		}},
+		{"records_service_skips_total", "counter", "derived runs skipped for failed or stale inputs", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return float64(c.Skips)
+		}},
+		{"records_service_queue_wait_seconds_total", "counter", "time spent waiting on the concurrency limits", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return c.QueueWait.Seconds()
+		}},
+		{"records_service_queue_wait_max_seconds", "gauge", "longest wait on the concurrency limits", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return c.QueueWaitMax.Seconds()
+		}},
+		{"records_service_store_emptied_total", "counter", "times the in memory store was emptied", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return float64(c.StoreEmptied)
+		}},
+		{"records_service_stored_records", "gauge", "records held in the in memory store", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return float64(c.Signature)
+		}},
+		{"records_service_errors", "gauge", "errors held in the store", func(s *serviceDetails, c *definitions.Counters) float64 {
+			_, errs := s.Store.Contents()
+			return float64(len(errs))
+		}},
+		{"records_service_spool_depth", "gauge", "records waiting in the spool", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return float64(sinks.Depth(s.Output))
+		}},
+		{"records_service_uptime_seconds", "gauge", "seconds since the service last started", func(s *serviceDetails, c *definitions.Counters) float64 {
+			return app.clock().Since(c.Start).Seconds()
+		}},
+	}
+	svs := app.getAllServiceData()
+	sort.Slice(svs, func(i, j int) bool { return svs[i].Name < svs[j].Name })
+	// one copy per service, the scheduler keeps counting while this renders
+	snapshots := make(map[*serviceDetails]*definitions.Counters)
+	for _, s := range svs {
+		snapshots[s] = s.Store.Counters.Snapshot()
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", c.name, c.help, c.name, c.kind)
+		for _, s := range svs {
+			fmt.Fprintf(w, "%v{%v,%v} %v\n", c.name, label("service", s.Name), label("id", s.ServiceId), c.value(s, snapshots[s]))
+		}
+	}
+
+	writes := make(map[*serviceDetails]map[string]int)
+	errs := make(map[*serviceDetails]map[string]int)
+	for _, s := range svs {
+		writes[s], errs[s] = snapshots[s].SinkWrites, snapshots[s].SinkErrors
+	}
+	for _, c := range []struct {
+		name, help string
+		counts     map[*serviceDetails]map[string]int
+	}{
+		{"records_service_sink_writes_total", "successful deliveries to a sink", writes},
+		{"records_service_sink_errors_total", "failed deliveries to a sink", errs},
+	} {
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", c.name, c.help, c.name)
+		for _, s := range svs {
+			names := make([]string, 0, len(c.counts[s]))
+			for name := range c.counts[s] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(w, "%v{%v,%v,%v} %v\n", c.name, label("service", s.Name), label("id", s.ServiceId), label("sink", name), c.counts[s][name])
+			}
+		}
+	}
+}
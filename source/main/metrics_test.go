@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+func Test_metricsRegistry(t *testing.T) {
+	m := newMetricsRegistry()
+	m.observe("rtsc_monitor", definitions.ZincRecordV2{
+		Index:   "ercotRTSC",
+		Records: []map[string]interface{}{{"freq": 60.01, "info": "ok", "error": false}},
+	})
+	m.observe("weather_monitor", definitions.ZincRecordV2{
+		Index: "verySpecialWeather",
+		Records: []map[string]interface{}{
+			{"location": map[string]interface{}{"name": "Houston"}, "current": map[string]interface{}{"temp_c": 10.5}},
+			{"location": map[string]interface{}{"name": "Austin"}, "current": map[string]interface{}{"temp_c": 12.0}},
+			// nothing says which city this is, so it has no series
+			{"current": map[string]interface{}{"temp_c": 14.0}},
+		},
+	})
+	// a newer record replaces the last one
+	m.observe("rtsc_monitor", definitions.ZincRecordV2{
+		Index:   "ercotRTSC",
+		Records: []map[string]interface{}{{"freq": 59.98}},
+	})
+
+	var out strings.Builder
+	m.write(&out)
+	expected := `# TYPE records_current_temp_c gauge
+records_current_temp_c{service="weather_monitor",index="verySpecialWeather",item="Austin"} 12
+records_current_temp_c{service="weather_monitor",index="verySpecialWeather",item="Houston"} 10.5
+# TYPE records_freq gauge
+records_freq{service="rtsc_monitor",index="ercotRTSC"} 59.98
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, out.String())
+	}
+}
+
+func TestMetricsWhileRunning(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "scraped", Runtime: 30, Refresh: 10}
+	_, done := startService(t, s)
+	scrape := func() string {
+		rr := httptest.NewRecorder()
+		app.Metrics(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rr.Body.String()
+	}
+	// scrape for as long as the service runs, -race catches anything read without its lock
+	scraping := make(chan struct{})
+	go func() {
+		defer close(scraping)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				scrape()
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		if out := scrape(); !strings.Contains(out, `records_service_iterations_total{service="scraped"`) {
+			t.Errorf("expected the running service in the scrape, got %v", out)
+		}
+		clock.Advance(10 * time.Second)
+	}
+	waitDone(t, done)
+	<-scraping
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/schedule"
 )
 
 var app *Application
@@ -16,82 +22,414 @@ func AppReceiver(a *Application) {
 	app = a
 }
 
-func (s *serviceDetails) Run(wkr func(c chan definitions.ZincRecordV2)) {
-	newStream := make(chan definitions.ZincRecordV2)
+// clock is the application's clock, the real one unless a test has set another
+func (app *Application) clock() schedule.Clock {
+	if app.Clock == nil {
+		return schedule.System
+	}
+	return app.Clock
+}
+
+func (s *serviceDetails) Run(wkr definitions.Worker) {
 	if err := serviceValidator(s); err != nil {
 		s.ErrorLog.Println(err)
 		return
 	}
 
 	uid := uuid.Must(uuid.NewRandom()).String()
-	app.registerService(uid, s)
+	// set before registering, the handlers read it as soon as the service is listed
+	s.Mtx.Lock()
 	s.ServiceId = uid
-	t, z := s.StartAt[0], s.StartAt[1]
-	tz, _ := time.LoadLocation(z)
+	s.Mtx.Unlock()
+	app.registerService(uid, s)
 
-	// starts immediately
-	if !s.Scheduled {
-	runtime:
+	switch {
+	case len(s.DependsOn) > 0:
+		s.runDerived(wkr)
+	case len(s.Windows) > 0:
+		s.runWindows(wkr)
+	case !s.Scheduled:
+		// starts immediately
 		for {
-			s.Store.Counters.Start = time.Now()
-			s.InfoLog.Printf("%v (%v) is starting. running for %vs every %vs", s.ServiceId, s.Name, s.Runtime, s.Refresh)
-			for start := time.Now(); time.Since(start) < time.Second*time.Duration(s.Runtime); {
-				select {
-				case <-s.Kill:
-					break runtime
-				default:
-				}
-				go wkr(newStream)
-				msg := <-newStream
-				s.Store.Records = append(s.Store.Records, &msg)
-				go app.handleStore(s.ServiceId, s.Store)
-				time.Sleep(time.Duration(s.Refresh) * time.Second)
+			if killed := s.work(wkr, s.deadline()); killed {
+				break
 			}
 			if !s.ReRun {
-				s.InfoLog.Printf("exit condition for %v (%v) reached.", s.Name, s.ServiceId)
-				app.removeService(uid)
-				return
+				break
 			}
-			s.Store.Counters.Iterations += 1
+			s.Store.Counters.Iteration()
 			s.InfoLog.Println(s.Name, "rotating service")
 		}
-	}
-
-	if s.Scheduled {
-		s.InfoLog.Printf("%v initialized. waiting for work to start at %v", s.Name, s.StartAt)
+	default:
+		// serviceValidator already made sure this parses
+		sched, _ := s.schedule()
+		trig := &schedule.Trigger{
+			Schedule: sched,
+			Policy:   s.Misfire,
+			Grace:    time.Duration(s.MisfireGrace) * time.Second,
+			Clock:    app.clock(),
+		}
+		s.InfoLog.Printf("%v initialized. waiting for work to start at %v", s.Name, s.scheduleSpec())
 	schedule:
 		for {
 			// this branch waits for scheduled time to occur
-			if time.Now().In(tz).Format("15:04") != t {
-				s.Waiting = true
-				time.Sleep(2 * time.Second)
-			} else {
-				s.Waiting = false
-				s.Store.Counters.Start = time.Now()
-				s.InfoLog.Printf("%v is starting. running for %vs every %vs", s.Name, s.Runtime, s.Refresh)
-				for start := time.Now(); time.Since(start) < time.Second*time.Duration(s.Runtime); {
-					select {
-					case <-s.Kill:
-						break schedule
-					default:
-					}
-					go wkr(newStream)
-					msg := <-newStream
-					s.Store.Records = append(s.Store.Records, &msg)
-					go app.handleStore(s.ServiceId, s.Store)
-					time.Sleep(time.Duration(s.Refresh) * time.Second)
-				}
-				if !s.ReRun {
-					s.InfoLog.Printf("exit condition for %v (%v) reached.", s.Name, s.ServiceId)
-					app.removeService(s.ServiceId)
-					return
+			next, missed := trig.Next()
+			if next.IsZero() {
+				s.ErrorLog.Printf("%v will never run again, %v has no future times", s.Name, s.scheduleSpec())
+				break
+			}
+			if missed > 0 {
+				s.Store.Counters.Misfire(missed)
+				s.ErrorLog.Printf("%v missed %v start time(s), next run at %v (misfire policy %q)", s.Name, missed, next, s.Misfire)
+			}
+			s.setNextRun(&next, true)
+			select {
+			case <-s.Kill:
+				break schedule
+			case <-trig.Clock.After(next.Sub(trig.Clock.Now())):
+			}
+			s.setNextRun(&next, false)
+			if s.Gate.Paused() {
+				// the start time passes while paused, misfire decides what happens to it
+				if s.hold() {
+					break
 				}
-				s.Store.Counters.Iterations += 1
-				s.InfoLog.Println(s.Name, "rotating service")
+				continue
+			}
+			if killed := s.work(wkr, s.deadline()); killed {
+				break
+			}
+			if !s.ReRun {
+				break
 			}
+			s.Store.Counters.Iteration()
+			s.InfoLog.Println(s.Name, "rotating service")
 		}
 	}
+	s.setNextRun(nil, false)
 	s.InfoLog.Printf("exit condition for %v (%v) reached.", s.Name, s.ServiceId)
 	app.removeService(s.ServiceId)
 
 }
+
+// runWindows starts the worker loop when one of the service's windows opens and stops it
+// when the window closes, skipping holidays. it carries on with the next window until the
+// service is stopped, rerun only matters to services bounded by their runtime
+func (s *serviceDetails) runWindows(wkr definitions.Worker) {
+	// serviceValidator already made sure this parses
+	cal, _ := s.calendar()
+	clock := app.clock()
+	for {
+		now := clock.Now()
+		end, open := cal.Open(now)
+		if !open {
+			next := cal.NextOpen(now)
+			if next.IsZero() {
+				s.ErrorLog.Printf("%v will never run again, no window opens in the next year", s.Name)
+				return
+			}
+			s.InfoLog.Printf("%v is outside its windows, waiting until %v", s.Name, next)
+			s.setNextRun(&next, true)
+			select {
+			case <-s.Kill:
+				return
+			case <-clock.After(next.Sub(clock.Now())):
+			}
+			s.setNextRun(&next, false)
+			continue
+		}
+		s.setNextRun(nil, false)
+		if s.Gate.Paused() {
+			if s.hold() {
+				return
+			}
+			continue
+		}
+		if killed := s.work(wkr, end); killed {
+			return
+		}
+		s.Store.Counters.Iteration()
+		s.InfoLog.Println(s.Name, "window closed")
+	}
+}
+
+// the ways a service can pace its worker
+const (
+	// modeDelay waits refresh seconds after each run finishes, the default
+	modeDelay = "delay"
+	// modeFixed starts a run every refresh seconds however long the last one took
+	modeFixed = "fixed"
+)
+
+// what a fixed rate service does with a tick that comes while its worker is still running
+const (
+	// overlapSkip drops the tick, the default
+	overlapSkip = "skip"
+	// overlapQueue runs once more as soon as the worker finishes, extra ticks are dropped
+	overlapQueue = "queue"
+	// overlapConcurrent starts another worker alongside
+	overlapConcurrent = "concurrent"
+)
+
+// work runs the worker every refresh seconds until the deadline, it reports whether the
+// service was killed along the way
+func (s *serviceDetails) work(wkr definitions.Worker, until time.Time) bool {
+	clock := app.clock()
+	s.Store.Counters.Started(clock.Now())
+	s.InfoLog.Printf("%v (%v) is starting. running until %v every %vs", s.ServiceId, s.Name, until.Format(time.RFC3339), s.Refresh)
+	if s.Mode == modeFixed {
+		return s.workFixed(wkr, until)
+	}
+	for clock.Now().Before(until) {
+		if s.hold() {
+			return true
+		}
+		// a pause can outlast the runtime or the window
+		if !clock.Now().Before(until) {
+			break
+		}
+		s.run(wkr)
+		select {
+		case <-s.Kill:
+			return true
+		case <-clock.After(time.Duration(s.Refresh) * time.Second):
+		}
+	}
+	return false
+}
+
+// workFixed starts the worker on every tick of the refresh interval, aligned to the clock
+// and jittered if asked, so the pace doesn't drift with how long the worker takes
+func (s *serviceDetails) workFixed(wkr definitions.Worker, until time.Time) bool {
+	clock := app.clock()
+	// serviceValidator already made sure this loads
+	loc, _ := s.location()
+	iv := &schedule.Interval{
+		Every: time.Duration(s.Refresh) * time.Second,
+		Start: clock.Now(),
+		Align: s.Align,
+		Loc:   loc,
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	var mtx sync.Mutex
+	running, pending := 0, false
+	dispatch := func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		// ticks while paused are let go so the pace picks up where it would have been
+		if s.Gate.Paused() {
+			return
+		}
+		if running > 0 && s.Overlap != overlapConcurrent {
+			s.Store.Counters.Overlap()
+			pending = s.Overlap == overlapQueue
+			return
+		}
+		running += 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				s.run(wkr)
+				mtx.Lock()
+				if !pending {
+					running -= 1
+					mtx.Unlock()
+					return
+				}
+				pending = false
+				mtx.Unlock()
+			}
+		}()
+	}
+
+	// a tick landing right now counts
+	next := iv.Next(clock.Now().Add(-time.Nanosecond))
+	for next.Before(until) {
+		wait := next.Sub(clock.Now())
+		if s.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(s.Jitter) * int64(time.Second)))
+		}
+		select {
+		case <-s.Kill:
+			return true
+		case <-clock.After(wait):
+		}
+		dispatch()
+		now := clock.Now()
+		if next = iv.Next(next); !next.After(now) {
+			next = iv.Next(now)
+		}
+	}
+	return false
+}
+
+// hold blocks while the service is paused, it reports whether the service was killed
+func (s *serviceDetails) hold() bool {
+	select {
+	case <-s.Kill:
+		return true
+	case <-s.Gate.Open():
+		return false
+	}
+}
+
+// state is how the runtime listing describes the service
+func (s *serviceDetails) state() string {
+	switch {
+	case s.Gate.Paused():
+		return "paused"
+	case s.copy().Waiting:
+		return "waiting"
+	}
+	return "running"
+}
+
+// setNextRun records when the service starts next and whether it is waiting for it
+func (s *serviceDetails) setNextRun(next *time.Time, waiting bool) {
+	s.Mtx.Lock()
+	defer s.Mtx.Unlock()
+	s.NextRun, s.Waiting = next, waiting
+}
+
+// copy returns the service as it is now, for the api to read while the scheduler runs it
+func (s *serviceDetails) copy() serviceDetails {
+	// a service that never started has nothing changing it
+	if s.Mtx == nil {
+		return *s
+	}
+	s.Mtx.Lock()
+	defer s.Mtx.Unlock()
+	return *s
+}
+
+// run collects once and hands the record to the store
+func (s *serviceDetails) run(wkr definitions.Worker) {
+	clock := app.clock()
+	queued := clock.Now()
+	release, ok := app.Limiter.acquire(s.Priority, s.Hosts, s.Kill)
+	if !ok {
+		return
+	}
+	s.Store.Counters.Waited(clock.Since(queued))
+	msg, err := s.collect(wkr)
+	release()
+	if errors.Is(err, definitions.ErrNothingNew) {
+		s.Store.Counters.Skip(err.Error())
+		return
+	}
+	var warn *definitions.Warning
+	if errors.As(err, &warn) {
+		// the record is still good
+		s.ErrorLog.Println(err)
+		s.Store.AddError(err)
+		err = nil
+	}
+	// services depending on this one get the outcome either way
+	app.Results.publish(s.Name, &result{Record: msg, Err: err, At: app.clock().Now()})
+	if err != nil {
+		s.ErrorLog.Println(err)
+		s.Store.AddError(err)
+		return
+	}
+	s.Store.AddRecord(&msg)
+	go app.handleStore(s.ServiceId, msg)
+}
+
+// schedule parses when a scheduled service should start, either its cron expression (in
+// time_zone) or the older start_at time of day
+func (s *serviceDetails) schedule() (*schedule.Cron, error) {
+	if s.Cron == "" {
+		return schedule.Daily(s.StartAt)
+	}
+	loc, err := s.location()
+	if err != nil {
+		return nil, err
+	}
+	return schedule.Parse(s.Cron, loc)
+}
+
+// location is the service's time_zone, local time unless set
+func (s *serviceDetails) location() (*time.Location, error) {
+	if s.TimeZone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(s.TimeZone)
+}
+
+// collect calls the worker once, giving up on it after the service's timeout
+func (s *serviceDetails) collect(wkr definitions.Worker) (definitions.ZincRecordV2, error) {
+	timeout := time.Duration(s.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = time.Duration(s.Refresh) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	type result struct {
+		msg definitions.ZincRecordV2
+		err error
+	}
+	// buffered so a worker that ignores ctx can still finish and go away
+	done := make(chan result, 1)
+	go func() {
+		msg, err := wkr.Collect(ctx)
+		done <- result{msg, err}
+	}()
+	select {
+	case r := <-done:
+		var warn *definitions.Warning
+		if errors.As(r.err, &warn) {
+			return r.msg, fmt.Errorf("%v worker: %w", s.Name, r.err)
+		}
+		if r.err != nil {
+			return r.msg, fmt.Errorf("%v worker failed: %w", s.Name, r.err)
+		}
+		return r.msg, nil
+	case <-ctx.Done():
+		return definitions.ZincRecordV2{}, fmt.Errorf("%v worker timed out after %v", s.Name, timeout)
+	}
+}
+
+func (s *serviceDetails) deadline() time.Time {
+	return app.clock().Now().Add(time.Second * time.Duration(s.Runtime))
+}
+
+// calendar builds the service's windows, each in its own time_zone or else the service's,
+// along with the holidays file if one is set
+func (s *serviceDetails) calendar() (*schedule.Calendar, error) {
+	loc, err := s.location()
+	if err != nil {
+		return nil, err
+	}
+	cal := &schedule.Calendar{}
+	for _, w := range s.Windows {
+		wloc := loc
+		if w.TimeZone != "" {
+			var err error
+			if wloc, err = time.LoadLocation(w.TimeZone); err != nil {
+				return nil, err
+			}
+		}
+		win, err := schedule.ParseWindow(w.Days, w.Start, w.End, wloc)
+		if err != nil {
+			return nil, err
+		}
+		cal.Windows = append(cal.Windows, win)
+	}
+	if s.Holidays != "" {
+		var err error
+		if cal.Holidays, err = schedule.LoadHolidays(s.Holidays); err != nil {
+			return nil, err
+		}
+	}
+	return cal, nil
+}
+
+func (s *serviceDetails) scheduleSpec() string {
+	if s.Cron != "" {
+		return s.Cron
+	}
+	return fmt.Sprint(s.StartAt)
+}
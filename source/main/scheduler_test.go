@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/schedule"
+)
+
+// schedulerApp points the scheduler at an application running on a fake clock
+func schedulerApp(t *testing.T, now time.Time) *schedule.FakeClock {
+	t.Helper()
+	clock := schedule.NewFakeClock(now)
+	previous := app
+	AppReceiver(&Application{
+		InfoLog:         log.New(io.Discard, "", 0),
+		ErrorLog:        log.New(io.Discard, "", 0),
+		Config:          &RuntimeConfig{DataDir: t.TempDir()},
+		ServiceRegistry: make(map[string]string),
+		StateMap:        make(map[string]*serviceDetails),
+		Gauges:          newMetricsRegistry(),
+		Results:         newResultHub(),
+		Clock:           clock,
+	})
+	t.Cleanup(func() { AppReceiver(previous) })
+	return clock
+}
+
+// startService runs s in the background with a worker that always succeeds
+func startService(t *testing.T, s *serviceDetails) (*int32, chan struct{}) {
+	t.Helper()
+	return startWorker(t, s, func(ctx context.Context) (definitions.ZincRecordV2, error) {
+		return definitions.ZincRecordV2{Index: "test"}, nil
+	})
+}
+
+// startWorker runs s in the background, returning how many times its worker was called
+// and a channel closed once Run returns
+func startWorker(t *testing.T, s *serviceDetails, wkr definitions.WorkerFunc) (*int32, chan struct{}) {
+	t.Helper()
+	s.Sink = &definitions.SinkConfig{Type: "file", DisableSpool: true}
+	if err := app.initService(s); err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(definitions.WorkerFunc(func(ctx context.Context) (definitions.ZincRecordV2, error) {
+			atomic.AddInt32(&calls, 1)
+			return wkr(ctx)
+		}))
+	}()
+	return &calls, done
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("service never exited")
+	}
+	app.Mtx.RLock()
+	defer app.Mtx.RUnlock()
+	if len(app.StateMap) != 0 {
+		t.Errorf("expected the service to be removed, found %v", len(app.StateMap))
+	}
+}
+
+// counters reads s's counters under their lock, a record may still be on its way to the sink
+func counters(t *testing.T, s *serviceDetails) *definitions.Counters {
+	t.Helper()
+	out, err := s.Store.Counters.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c definitions.Counters
+	if err := json.Unmarshal(out, &c); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func TestRunImmediate(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	calls, done := startService(t, &serviceDetails{Name: "immediate", Runtime: 30, Refresh: 10})
+	// the worker runs at 0s, 10s and 20s, at 30s the runtime is up
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Second)
+	}
+	waitDone(t, done)
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Errorf("expected 3 calls, got %v", got)
+	}
+}
+
+func TestRunReRun(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "rerun", Runtime: 20, Refresh: 10, ReRun: true}
+	calls, done := startService(t, s)
+	// two full rotations, then kill the service during the third
+	for i := 0; i < 5; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Second)
+	}
+	clock.BlockUntil(1)
+	close(s.Kill)
+	waitDone(t, done)
+	if got := atomic.LoadInt32(calls); got != 6 {
+		t.Errorf("expected 6 calls, got %v", got)
+	}
+	if got := counters(t, s).Iterations; got != 2 {
+		t.Errorf("expected 2 iterations, got %v", got)
+	}
+}
+
+func TestRunKilled(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "killed", Runtime: 3600, Refresh: 60}
+	calls, done := startService(t, s)
+	clock.BlockUntil(1)
+	close(s.Kill)
+	waitDone(t, done)
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("expected 1 call, got %v", got)
+	}
+}
+
+func TestRunScheduled(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 30, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "scheduled", Runtime: 60, Refresh: 30, Scheduled: true, Cron: "0 11 * * *", TimeZone: "UTC"}
+	app.Config.Services = []*serviceDetails{s}
+	calls, done := startService(t, s)
+	get := func(handler http.HandlerFunc) string {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr.Body.String()
+	}
+	// the api reads the schedule the whole time it changes, -race catches an unlocked read
+	listing := make(chan struct{})
+	go func() {
+		defer close(listing)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				get(app.ListLoaded)
+				get(app.ListServices)
+			}
+		}
+	}()
+
+	clock.BlockUntil(1)
+	if out := get(app.ListLoaded); !strings.Contains(out, `"next_run":"2022-12-14T11:00:00Z"`) {
+		t.Fatalf("expected the loaded service to start at 11:00, got %v", out)
+	}
+	if out := get(app.ListServices); !strings.Contains(out, `"state":"waiting"`) {
+		t.Fatalf("expected the service to be waiting, got %v", out)
+	}
+	if got := atomic.LoadInt32(calls); got != 0 {
+		t.Fatalf("expected no calls before the start time, got %v", got)
+	}
+	clock.Advance(30 * time.Minute)
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(30 * time.Second)
+	}
+	waitDone(t, done)
+	<-listing
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("expected 2 calls, got %v", got)
+	}
+}
+
+func TestRunScheduledKilledWhileWaiting(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 30, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "waiting", Runtime: 60, Refresh: 30, Scheduled: true, Cron: "0 11 * * *"}
+	calls, done := startService(t, s)
+	clock.BlockUntil(1)
+	close(s.Kill)
+	waitDone(t, done)
+	if got := atomic.LoadInt32(calls); got != 0 {
+		t.Errorf("expected no calls, got %v", got)
+	}
+}
+
+func TestRunWindows(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 9, 55, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "windowed", Refresh: 600, TimeZone: "UTC", Windows: []*definitions.Window{{Start: "10:00", End: "10:20"}}}
+	calls, done := startService(t, s)
+	// runs at 10:00 and 10:10, then waits for tomorrow's window without rerun set
+	for _, d := range []time.Duration{5 * time.Minute, 10 * time.Minute, 10 * time.Minute, 23*time.Hour + 40*time.Minute} {
+		clock.BlockUntil(1)
+		clock.Advance(d)
+	}
+	clock.BlockUntil(1)
+	close(s.Kill)
+	waitDone(t, done)
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Errorf("expected 3 calls over two windows, got %v", got)
+	}
+	if got := counters(t, s).Iterations; got != 1 {
+		t.Errorf("expected 1 iteration, got %v", got)
+	}
+}
+
+func TestRunWorkerErrors(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "failing", Runtime: 20, Refresh: 10}
+	calls, done := startWorker(t, s, func(ctx context.Context) (definitions.ZincRecordV2, error) {
+		return definitions.ZincRecordV2{}, errors.New("upstream is down")
+	})
+	// a failing worker must not wedge the service
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Second)
+	}
+	waitDone(t, done)
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("expected 2 calls, got %v", got)
+	}
+	records, errs := s.Store.Contents()
+	if len(errs) != 2 || !strings.Contains((*errs[0]).Error(), "upstream is down") {
+		t.Errorf("expected the failures in the store, got %v", errs)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no records, got %v", len(records))
+	}
+}
+
+func TestRunWorkerWarning(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "warning", Runtime: 10, Refresh: 10}
+	_, done := startWorker(t, s, func(ctx context.Context) (definitions.ZincRecordV2, error) {
+		return definitions.ZincRecordV2{Index: "test"}, &definitions.Warning{Err: errors.New("feed is stale")}
+	})
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	waitDone(t, done)
+	records, errs := s.Store.Contents()
+	if len(records) != 1 {
+		t.Errorf("expected the record to be kept, got %v", len(records))
+	}
+	if len(errs) != 1 || !strings.Contains((*errs[0]).Error(), "feed is stale") {
+		t.Errorf("expected the warning in the store, got %v", errs)
+	}
+}
+
+func TestRunWorkerTimeout(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "stuck", Runtime: 10, Refresh: 10, Timeout: 1}
+	// ignores its context entirely
+	stuck := make(chan struct{})
+	defer close(stuck)
+	_, done := startWorker(t, s, func(ctx context.Context) (definitions.ZincRecordV2, error) {
+		<-stuck
+		return definitions.ZincRecordV2{}, nil
+	})
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	waitDone(t, done)
+	if _, errs := s.Store.Contents(); len(errs) != 1 || !strings.Contains((*errs[0]).Error(), "timed out after 1s") {
+		t.Errorf("expected a timeout in the store, got %v", errs)
+	}
+}
+
+func TestRunFixedOverlap(t *testing.T) {
+	tests := []struct {
+		overlap  string
+		calls    int32
+		overlaps int
+	}{
+		{overlapSkip, 1, 3},
+		{overlapQueue, 2, 3},
+		{overlapConcurrent, 4, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.overlap, func(t *testing.T) {
+			clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 7, 0, 0, time.UTC))
+			s := &serviceDetails{Name: "fixed", Runtime: 3600, Refresh: 900, Mode: modeFixed, Align: true, Overlap: tc.overlap, TimeZone: "UTC"}
+			// every run outlasts the interval until the test lets it go
+			release := make(chan struct{})
+			calls, done := startWorker(t, s, func(ctx context.Context) (definitions.ZincRecordV2, error) {
+				<-release
+				return definitions.ZincRecordV2{Index: "test"}, nil
+			})
+			// ticks at 10:15, 10:30, 10:45 and 11:00, the runtime ends at 11:07
+			clock.BlockUntil(1)
+			clock.Advance(8 * time.Minute)
+			for i := 0; i < 3; i++ {
+				clock.BlockUntil(1)
+				clock.Advance(15 * time.Minute)
+			}
+			// the last tick is dispatched on the service's goroutine, let it land first
+			eventually(t, func() bool {
+				return int(atomic.LoadInt32(calls))+counters(t, s).Overlaps == 4
+			})
+			close(release)
+			waitDone(t, done)
+			if got := atomic.LoadInt32(calls); got != tc.calls {
+				t.Errorf("expected %v calls, got %v", tc.calls, got)
+			}
+			if got := counters(t, s).Overlaps; got != tc.overlaps {
+				t.Errorf("expected %v overlaps, got %v", tc.overlaps, got)
+			}
+		})
+	}
+}
+
+// held waits for a paused service to block on its gate, the clock can't tell
+func held(t *testing.T) {
+	t.Helper()
+	eventually(t, func() bool {
+		buf := make([]byte, 1<<20)
+		return strings.Contains(string(buf[:runtime.Stack(buf, true)]), ".(*serviceDetails).hold(")
+	})
+}
+
+func TestRunPauseResume(t *testing.T) {
+	clock := schedulerApp(t, time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	s := &serviceDetails{Name: "pausable", Runtime: 60, Refresh: 10}
+	calls, done := startService(t, s)
+	clock.BlockUntil(1)
+	id := s.ServiceId
+
+	post := func(handler http.HandlerFunc, body string) jsonResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		var out jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	if res := post(app.PauseService, `{"id": "`+id+`"}`); res.Error {
+		t.Fatalf("pause failed: %v", res.Message)
+	}
+	if res := post(app.PauseService, `{"id": "`+id+`"}`); !res.Error {
+		t.Error("expected pausing twice to fail")
+	}
+	if res := post(app.PauseService, `{"id": "nope"}`); !res.Error {
+		t.Error("expected an unknown id to fail")
+	}
+	rr := httptest.NewRecorder()
+	app.ListServices(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rr.Body.String(), `"pausable":{"id":"`+id+`","state":"paused"}`) {
+		t.Errorf("expected the listing to show the service paused, got %s", rr.Body.String())
+	}
+
+	// nothing runs while paused
+	clock.Advance(10 * time.Second)
+	clock.Advance(20 * time.Second)
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("expected 1 call while paused, got %v", got)
+	}
+	if res := post(app.ResumeService, `{"id": "`+id+`"}`); res.Error {
+		t.Fatalf("resume failed: %v", res.Message)
+	}
+	// the runtime kept counting, runs at 30s, 40s and 50s finish it
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Second)
+	}
+	waitDone(t, done)
+	if got := atomic.LoadInt32(calls); got != 4 {
+		t.Errorf("expected 4 calls, got %v", got)
+	}
+	if s.ServiceId != id {
+		t.Errorf("expected the service to keep its id %v, got %v", id, s.ServiceId)
+	}
+
+	// resumed after its runtime is up, a service finishes without another run
+	s = &serviceDetails{Name: "expired", Runtime: 60, Refresh: 10}
+	calls, done = startService(t, s)
+	clock.BlockUntil(1)
+	if res := post(app.PauseService, `{"id": "`+s.ServiceId+`"}`); res.Error {
+		t.Fatalf("pause failed: %v", res.Message)
+	}
+	clock.Advance(10 * time.Second)
+	held(t)
+	clock.Advance(2 * time.Hour)
+	if res := post(app.ResumeService, `{"id": "`+s.ServiceId+`"}`); res.Error {
+		t.Fatalf("resume failed: %v", res.Message)
+	}
+	waitDone(t, done)
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("expected 1 call for the expired service, got %v", got)
+	}
+
+	// and one resumed after its window closed waits for the next
+	s = &serviceDetails{Name: "closed", Refresh: 600, TimeZone: "UTC", Windows: []*definitions.Window{{Start: "10:00", End: "10:20"}}}
+	clock.Advance(time.Date(2022, 12, 15, 10, 0, 0, 0, time.UTC).Sub(clock.Now()))
+	calls, done = startService(t, s)
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Minute)
+	if res := post(app.PauseService, `{"id": "`+s.ServiceId+`"}`); res.Error {
+		t.Fatalf("pause failed: %v", res.Message)
+	}
+	clock.Advance(5 * time.Minute)
+	held(t)
+	clock.Advance(2 * time.Hour)
+	if res := post(app.ResumeService, `{"id": "`+s.ServiceId+`"}`); res.Error {
+		t.Fatalf("resume failed: %v", res.Message)
+	}
+	clock.BlockUntil(1)
+	close(s.Kill)
+	waitDone(t, done)
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("expected 1 call for the closed window, got %v", got)
+	}
+}
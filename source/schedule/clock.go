@@ -0,0 +1,100 @@
+package schedule
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is where the scheduler and workers get the time from, tests swap it for a
+// FakeClock they control
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// System is the real clock
+var System Clock = systemClock{}
+
+// FakeClock only moves when told to. anything waiting on After or Sleep is released once
+// Advance carries the clock past its deadline.
+type FakeClock struct {
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFakeClock returns a clock stopped at now
+func NewFakeClock(now time.Time) *FakeClock {
+	f := &FakeClock{now: now}
+	f.cond = sync.NewCond(&f.mtx)
+	return f
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.now
+}
+
+func (f *FakeClock) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.waiters = append(f.waiters, &waiter{at: f.now.Add(d), c: c})
+	f.cond.Broadcast()
+	return c
+}
+
+func (f *FakeClock) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Advance moves the clock forward, releasing the waiters it passes in deadline order
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.now = f.now.Add(d)
+	sort.Slice(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- w.at
+	}
+	f.waiters = pending
+}
+
+// BlockUntil waits until n callers are blocked on After or Sleep
+func (f *FakeClock) BlockUntil(n int) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
@@ -0,0 +1,296 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression. it matches wall clock time in its location.
+type Cron struct {
+	second, minute, hour, dom, month, dow uint64
+	// when both day fields are restricted a day matching either one will do
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	seconds = bounds{0, 59, nil}
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse reads a standard 5 field (minute hour dom month dow) or 6 field (with a leading
+// seconds field) cron expression. names like `mon-fri` and `jan`, lists, ranges, steps
+// and the @daily style descriptors are understood. a leading CRON_TZ=<zone> or TZ=<zone>
+// overrides loc, which defaults to local time.
+func Parse(spec string, loc *time.Location) (*Cron, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.Index(spec, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("missing expression after time zone in %q", spec)
+		}
+		var err error
+		loc, err = time.LoadLocation(spec[strings.Index(spec, "=")+1 : i])
+		if err != nil {
+			return nil, err
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if d, ok := descriptors[spec]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("expected 5 or 6 fields in %q, found %v", spec, len(fields))
+	}
+	c := &Cron{loc: loc}
+	var err error
+	for i, f := range []struct {
+		field *uint64
+		b     bounds
+	}{
+		{&c.second, seconds},
+		{&c.minute, minutes},
+		{&c.hour, hours},
+		{&c.dom, doms},
+		{&c.month, months},
+		{&c.dow, dows},
+	} {
+		if *f.field, err = parseField(fields[i], f.b); err != nil {
+			return nil, fmt.Errorf("bad cron field %q: %w", fields[i], err)
+		}
+	}
+	// sunday can be 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = fields[3] == "*" || fields[3] == "?"
+	c.dowStar = fields[5] == "*" || fields[5] == "?"
+	return c, nil
+}
+
+// ParseDays reads a day of week list such as `mon-fri` or `sat,sun` into a weekday set
+func ParseDays(spec string) (map[time.Weekday]bool, error) {
+	bits, err := parseField(spec, dows)
+	if err != nil {
+		return nil, err
+	}
+	days := make(map[time.Weekday]bool)
+	for d := 0; d <= 7; d++ {
+		if bits&(1<<d) != 0 {
+			days[time.Weekday(d%7)] = true
+		}
+	}
+	return days, nil
+}
+
+// parseField turns a comma separated list of values, ranges and steps into a bit set
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(strings.ToLower(field), ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := b.min, b.max
+		switch {
+		case part == "*" || part == "?":
+			if b.max == 7 {
+				// 7 is only an alias for sunday
+				hi = 6
+			}
+		case strings.Contains(part, "-"):
+			ends := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = value(ends[0], b); err != nil {
+				return 0, err
+			}
+			if hi, err = value(ends[1], b); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := value(part, b)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("range %q runs backwards", part)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func value(s string, b bounds) (int, error) {
+	if v, ok := b.names[s]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number or a name", s)
+	}
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("%v is outside %v-%v", v, b.min, b.max)
+	}
+	return v, nil
+}
+
+// Location is the time zone the expression is evaluated in
+func (c *Cron) Location() *time.Location {
+	return c.loc
+}
+
+// Next returns the first time after `after` the expression fires, or the zero time if it
+// never does in the next five years
+func (c *Cron) Next(after time.Time) time.Time {
+	after = after.In(c.loc)
+	// walk wall clock time in a zone without transitions, then place each match in loc
+	t := naive(after).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+
+wrap:
+	for t.Before(limit) {
+		for c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			if t.Month() == time.January {
+				continue wrap
+			}
+		}
+		for !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			if t.Day() == 1 {
+				continue wrap
+			}
+		}
+		for c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			if t.Hour() == 0 {
+				continue wrap
+			}
+		}
+		for c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			if t.Minute() == 0 {
+				continue wrap
+			}
+		}
+		for c.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			if t.Second() == 0 {
+				continue wrap
+			}
+		}
+		if fire := c.instant(t); fire.After(after) {
+			return fire
+		}
+		t = t.Add(time.Second)
+	}
+	return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// instant places a wall clock time (held in UTC) in the cron's location. a time skipped
+// when the clocks go forward fires at the moment of the change, and a time repeated when
+// they go back fires only the first time around.
+func (c *Cron) instant(wall time.Time) time.Time {
+	fire := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, c.loc)
+	start, end := fire.ZoneBounds()
+	if !sameWall(fire, wall) {
+		// in the gap, time.Date normalized to one side of it
+		if naive(fire).Before(wall) {
+			return end
+		}
+		return start
+	}
+	if start.IsZero() {
+		return fire
+	}
+	_, offset := fire.Zone()
+	_, before := start.Add(-time.Second).Zone()
+	if before > offset {
+		// in the repeated hour, prefer the earlier offset if it also reads as wall
+		if earlier := fire.Add(-time.Duration(before-offset) * time.Second); sameWall(earlier, wall) {
+			return earlier
+		}
+	}
+	return fire
+}
+
+func sameWall(t, wall time.Time) bool {
+	return naive(t).Equal(wall)
+}
+
+// naive is t's wall clock reading held in UTC
+func naive(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// Daily builds the expression for a legacy `start_at` of ["HH:MM", "Zone"]
+func Daily(startAt []string) (*Cron, error) {
+	if len(startAt) != 2 {
+		return nil, fmt.Errorf("start_at should look like [\"HH:MM\", \"Zone\"], got %v", startAt)
+	}
+	at, err := time.Parse("15:04", startAt[0])
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(startAt[1])
+	if err != nil {
+		return nil, err
+	}
+	return Parse(fmt.Sprintf("%v %v * * *", at.Minute(), at.Hour()), loc)
+}
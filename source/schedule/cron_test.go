@@ -0,0 +1,96 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestCronNext(t *testing.T) {
+	chicago := mustLoad(t, "America/Chicago")
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04:05", s, chicago)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		spec     string
+		after    string
+		expected string
+	}{
+		{"16 10 * * *", "2022-12-14 09:00:00", "2022-12-14 10:16:00"},
+		{"16 10 * * *", "2022-12-14 10:16:00", "2022-12-15 10:16:00"},
+		{"*/15 * * * *", "2022-12-14 10:16:00", "2022-12-14 10:30:00"},
+		{"30 */15 * * * *", "2022-12-14 10:16:00", "2022-12-14 10:30:30"},
+		{"0 6 * * mon-fri", "2022-12-16 07:00:00", "2022-12-19 06:00:00"},
+		{"0 0 1 jan,jul *", "2022-12-14 10:16:00", "2023-01-01 00:00:00"},
+		{"0 0 29 2 *", "2022-12-14 10:16:00", "2024-02-29 00:00:00"},
+		{"0 12 13 * 5", "2022-12-14 10:16:00", "2022-12-16 12:00:00"},
+		{"0 0 * * 7", "2022-12-14 10:16:00", "2022-12-18 00:00:00"},
+		{"@hourly", "2022-12-14 10:16:00", "2022-12-14 11:00:00"},
+		{"CRON_TZ=UTC 0 18 * * *", "2022-12-14 10:16:00", "2022-12-14 12:00:00"},
+	}
+	for _, tc := range tests {
+		c, err := Parse(tc.spec, chicago)
+		if err != nil {
+			t.Errorf("%v: %v", tc.spec, err)
+			continue
+		}
+		if got := c.Next(at(tc.after)); !got.Equal(at(tc.expected)) {
+			t.Errorf("%v after %v: expected %v, got %v", tc.spec, tc.after, tc.expected, got.In(chicago))
+		}
+	}
+}
+
+func TestCronParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* * * * * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"CRON_TZ=Nowhere/Special * * * * *",
+	} {
+		if _, err := Parse(spec, time.UTC); err == nil {
+			t.Errorf("expected %q to fail", spec)
+		}
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	chicago := mustLoad(t, "America/Chicago")
+	tests := []struct {
+		spec     string
+		after    time.Time
+		expected time.Time
+	}{
+		// 02:30 does not exist on 2023-03-12, it fires as the clocks jump to 03:00 CDT
+		{"30 2 * * *", time.Date(2023, 3, 12, 0, 0, 0, 0, chicago), time.Date(2023, 3, 12, 8, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2023, 3, 12, 8, 0, 0, 0, time.UTC), time.Date(2023, 3, 13, 7, 30, 0, 0, time.UTC)},
+		// 01:30 happens twice on 2022-11-06, first in CDT
+		{"30 1 * * *", time.Date(2022, 11, 6, 0, 0, 0, 0, chicago), time.Date(2022, 11, 6, 6, 30, 0, 0, time.UTC)},
+		// and does not fire again an hour later in CST
+		{"30 1 * * *", time.Date(2022, 11, 6, 6, 30, 0, 0, time.UTC), time.Date(2022, 11, 7, 7, 30, 0, 0, time.UTC)},
+	}
+	for _, tc := range tests {
+		c, err := Parse(tc.spec, chicago)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Next(tc.after); !got.Equal(tc.expected) {
+			t.Errorf("%v after %v: expected %v, got %v", tc.spec, tc.after.UTC(), tc.expected, got.UTC())
+		}
+	}
+}
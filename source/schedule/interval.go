@@ -0,0 +1,37 @@
+package schedule
+
+import "time"
+
+// Interval fires every Every counted from Start. when Align is set it counts from midnight
+// in Loc instead, so 15 minutes lands on :00, :15, :30 and :45 whenever it started.
+type Interval struct {
+	Every time.Duration
+	Start time.Time
+	Align bool
+	Loc   *time.Location
+}
+
+// Next returns the first tick after `after`
+func (iv *Interval) Next(after time.Time) time.Time {
+	if iv.Every <= 0 {
+		return time.Time{}
+	}
+	if !iv.Align {
+		if after.Before(iv.Start) {
+			return iv.Start
+		}
+		return iv.Start.Add((after.Sub(iv.Start)/iv.Every + 1) * iv.Every)
+	}
+	loc := iv.Loc
+	if loc == nil {
+		loc = time.Local
+	}
+	local := after.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	next := midnight.Add((after.Sub(midnight)/iv.Every + 1) * iv.Every)
+	// intervals that don't divide the day start over at midnight
+	if tomorrow := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc); next.After(tomorrow) {
+		return tomorrow
+	}
+	return next
+}
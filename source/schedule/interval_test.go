@@ -0,0 +1,29 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIntervalNext(t *testing.T) {
+	chicago := mustLoad(t, "America/Chicago")
+	start := time.Date(2022, 12, 14, 10, 7, 30, 0, chicago)
+	tests := []struct {
+		name     string
+		iv       Interval
+		after    time.Time
+		expected time.Time
+	}{
+		{"from start", Interval{Every: 15 * time.Minute, Start: start}, start, start.Add(15 * time.Minute)},
+		{"no drift", Interval{Every: 15 * time.Minute, Start: start}, start.Add(16 * time.Minute), start.Add(30 * time.Minute)},
+		{"before start", Interval{Every: time.Minute, Start: start}, start.Add(-time.Hour), start},
+		{"aligned", Interval{Every: 15 * time.Minute, Align: true, Loc: chicago}, start, time.Date(2022, 12, 14, 10, 15, 0, 0, chicago)},
+		{"on a boundary", Interval{Every: 15 * time.Minute, Align: true, Loc: chicago}, time.Date(2022, 12, 14, 10, 15, 0, 0, chicago), time.Date(2022, 12, 14, 10, 30, 0, 0, chicago)},
+		{"across midnight", Interval{Every: 7 * time.Hour, Align: true, Loc: chicago}, time.Date(2022, 12, 14, 22, 0, 0, 0, chicago), time.Date(2022, 12, 15, 0, 0, 0, 0, chicago)},
+	}
+	for _, tc := range tests {
+		if got := tc.iv.Next(tc.after); !got.Equal(tc.expected) {
+			t.Errorf("%v: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
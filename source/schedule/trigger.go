@@ -0,0 +1,81 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// Schedule is anything that can say when it next fires, like a Cron
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// what to do about fire times that passed while the service was busy or the process was
+// not running
+const (
+	// MisfireSkip forgets missed times and waits for the next one
+	MisfireSkip = "skip"
+	// MisfireRunOnce runs once straight away for any number of missed times
+	MisfireRunOnce = "run_once"
+	// MisfireCatchUp runs once for every missed time, back to back
+	MisfireCatchUp = "catch_up"
+)
+
+// ValidMisfire checks a misfire policy, an empty one is MisfireSkip
+func ValidMisfire(policy string) error {
+	switch policy {
+	case "", MisfireSkip, MisfireRunOnce, MisfireCatchUp:
+		return nil
+	}
+	return fmt.Errorf("unknown misfire policy %q, expected %v, %v or %v", policy, MisfireSkip, MisfireRunOnce, MisfireCatchUp)
+}
+
+// Trigger walks a schedule from one run to the next. a fire time that is late by no more
+// than Grace still counts as on time, later than that it is handled by Policy.
+type Trigger struct {
+	Schedule Schedule
+	Policy   string
+	Grace    time.Duration
+	Clock    Clock
+	last     time.Time
+}
+
+// Next returns when the next run is due, which is in the past when a missed time should
+// be run straight away, along with how many fire times were missed. it returns the zero
+// time once the schedule has nothing left.
+func (t *Trigger) Next() (time.Time, int) {
+	clock := t.Clock
+	if clock == nil {
+		clock = System
+	}
+	now := clock.Now()
+	if t.last.IsZero() {
+		t.last = now
+	}
+	next := t.Schedule.Next(t.last)
+	if next.IsZero() || !next.Before(now.Add(-t.Grace)) {
+		t.last = next
+		return next, 0
+	}
+
+	switch t.Policy {
+	case MisfireCatchUp:
+		// the rest are counted as they come due
+		t.last = next
+		return next, 1
+	case MisfireRunOnce:
+		missed := 0
+		for ; !next.IsZero() && !next.After(now); next = t.Schedule.Next(next) {
+			missed++
+			t.last = next
+		}
+		return now, missed
+	default:
+		missed := 0
+		for ; !next.IsZero() && next.Before(now.Add(-t.Grace)); next = t.Schedule.Next(next) {
+			missed++
+		}
+		t.last = next
+		return next, missed
+	}
+}
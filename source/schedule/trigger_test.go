@@ -0,0 +1,100 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTriggerMisfire(t *testing.T) {
+	hourly, err := Parse("@hourly", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour, min int) time.Time {
+		return time.Date(2022, 12, 14, hour, min, 0, 0, time.UTC)
+	}
+	type due struct {
+		now    time.Time
+		next   time.Time
+		missed int
+	}
+	tests := []struct {
+		policy string
+		grace  time.Duration
+		steps  []due
+	}{
+		{MisfireSkip, 0, []due{
+			{at(10, 5), at(11, 0), 0},
+			// busy from 11:00 until 13:30
+			{at(13, 30), at(14, 0), 2},
+		}},
+		{MisfireRunOnce, 0, []due{
+			{at(10, 5), at(11, 0), 0},
+			{at(13, 30), at(13, 30), 2},
+			{at(13, 31), at(14, 0), 0},
+		}},
+		{MisfireCatchUp, 0, []due{
+			{at(10, 5), at(11, 0), 0},
+			{at(13, 30), at(12, 0), 1},
+			{at(13, 31), at(13, 0), 1},
+			{at(13, 32), at(14, 0), 0},
+		}},
+		{MisfireSkip, 45 * time.Minute, []due{
+			{at(10, 5), at(11, 0), 0},
+			{at(12, 40), at(12, 0), 0},
+			{at(12, 41), at(13, 0), 0},
+		}},
+	}
+	for _, tc := range tests {
+		clock := NewFakeClock(tc.steps[0].now)
+		trig := &Trigger{Schedule: hourly, Policy: tc.policy, Grace: tc.grace, Clock: clock}
+		for i, step := range tc.steps {
+			clock.Advance(step.now.Sub(clock.Now()))
+			next, missed := trig.Next()
+			if !next.Equal(step.next) || missed != step.missed {
+				t.Errorf("%v grace %v step %v: expected %v (%v missed), got %v (%v missed)",
+					tc.policy, tc.grace, i, step.next.Format("15:04"), step.missed, next.Format("15:04"), missed)
+			}
+		}
+	}
+}
+
+func TestTriggerOnTime(t *testing.T) {
+	every, err := Parse("*/15 * * * *", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(time.Date(2022, 12, 14, 10, 1, 0, 0, time.UTC))
+	trig := &Trigger{Schedule: every, Clock: clock}
+	for _, expected := range []int{15, 30, 45} {
+		next, missed := trig.Next()
+		if next.Minute() != expected || missed != 0 {
+			t.Fatalf("expected :%v with nothing missed, got %v (%v missed)", expected, next, missed)
+		}
+		clock.Advance(next.Sub(clock.Now()))
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	woke := make(chan time.Time)
+	go func() {
+		clock.Sleep(time.Minute)
+		woke <- clock.Now()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
+	select {
+	case <-woke:
+		t.Fatal("sleeper woke early")
+	default:
+	}
+	clock.Advance(30 * time.Second)
+	if got := <-woke; !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected to wake at %v, got %v", start.Add(time.Minute), got)
+	}
+	if clock.Since(start) != time.Minute {
+		t.Errorf("expected a minute to have passed, got %v", clock.Since(start))
+	}
+}
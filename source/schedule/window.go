@@ -0,0 +1,141 @@
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Window is a span of the day on certain weekdays, wall clock time in its location. an end
+// at or before the start means the window runs past midnight into the next day.
+type Window struct {
+	days       map[time.Weekday]bool
+	start, end time.Duration
+	loc        *time.Location
+}
+
+// ParseWindow builds a window from a day list like `mon-fri` and "HH:MM" start and end times
+func ParseWindow(days, start, end string, loc *time.Location) (*Window, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	if days == "" {
+		days = "*"
+	}
+	w := &Window{loc: loc}
+	var err error
+	if w.days, err = ParseDays(days); err != nil {
+		return nil, fmt.Errorf("bad window days %q: %w", days, err)
+	}
+	if w.start, err = clock(start); err != nil {
+		return nil, err
+	}
+	if w.end, err = clock(end); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func clock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad window time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// span returns when the window opens and closes if it opens on the same calendar day as t
+func (w *Window) span(t time.Time) (time.Time, time.Time, bool) {
+	t = t.In(w.loc)
+	if !w.days[t.Weekday()] {
+		return time.Time{}, time.Time{}, false
+	}
+	y, m, d := t.Date()
+	at := func(day int, offset time.Duration) time.Time {
+		return time.Date(y, m, day, int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, w.loc)
+	}
+	open, close := at(d, w.start), at(d, w.end)
+	if w.end <= w.start {
+		close = at(d+1, w.end)
+	}
+	return open, close, true
+}
+
+// Calendar is a set of windows with days excluded by a holiday list
+type Calendar struct {
+	Windows  []*Window
+	Holidays map[string]bool
+}
+
+// occurrences calls fn with every window opening on the days around t, holidays skipped.
+// a window on a holiday is judged by the day it opens.
+func (c *Calendar) occurrences(t time.Time, days int, fn func(open, close time.Time)) {
+	for _, w := range c.Windows {
+		local := t.In(w.loc)
+		for i := -1; i <= days; i++ {
+			day := time.Date(local.Year(), local.Month(), local.Day()+i, 12, 0, 0, 0, w.loc)
+			if c.Holidays[day.Format("2006-01-02")] {
+				continue
+			}
+			if open, close, ok := w.span(day); ok {
+				fn(open, close)
+			}
+		}
+	}
+}
+
+// Open reports whether t falls inside a window and if so when it closes. windows that
+// overlap or touch are treated as one.
+func (c *Calendar) Open(t time.Time) (time.Time, bool) {
+	var end time.Time
+	for probe := t; ; probe = end {
+		extended := false
+		c.occurrences(probe, 1, func(open, close time.Time) {
+			if !probe.Before(open) && probe.Before(close) && close.After(end) {
+				end = close
+				extended = true
+			}
+		})
+		if !extended {
+			return end, !end.IsZero()
+		}
+	}
+}
+
+// NextOpen returns the first time after t a window opens, or the zero time if none does
+// within a year
+func (c *Calendar) NextOpen(t time.Time) time.Time {
+	var next time.Time
+	c.occurrences(t, 366, func(open, close time.Time) {
+		if open.After(t) && (next.IsZero() || open.Before(next)) {
+			next = open
+		}
+	})
+	return next
+}
+
+// LoadHolidays reads a file of YYYY-MM-DD dates, one per line. anything after the date and
+// lines starting with # are ignored.
+func LoadHolidays(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	holidays := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%v line %v: %w", path, n, err)
+		}
+		holidays[day.Format("2006-01-02")] = true
+	}
+	return holidays, scanner.Err()
+}
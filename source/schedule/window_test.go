@@ -0,0 +1,92 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCalendar(t *testing.T) {
+	chicago := mustLoad(t, "America/Chicago")
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, chicago)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	weekdays, err := ParseWindow("mon-fri", "06:00", "22:00", chicago)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weekends, err := ParseWindow("sat,sun", "20:00", "02:00", chicago)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cal := &Calendar{
+		Windows:  []*Window{weekdays, weekends},
+		Holidays: map[string]bool{"2022-12-26": true},
+	}
+
+	tests := []struct {
+		now  string
+		open bool
+		// when open the window's close, otherwise the next time one opens
+		expected string
+	}{
+		{"2022-12-14 09:00", true, "2022-12-14 22:00"},
+		{"2022-12-14 22:00", false, "2022-12-15 06:00"},
+		{"2022-12-16 23:00", false, "2022-12-17 20:00"},
+		// saturday night runs into sunday morning
+		{"2022-12-18 01:00", true, "2022-12-18 02:00"},
+		// the sunday window runs past midnight, monday the 26th is a holiday
+		{"2022-12-25 21:00", true, "2022-12-26 02:00"},
+		{"2022-12-26 03:00", false, "2022-12-27 06:00"},
+	}
+	for _, tc := range tests {
+		end, open := cal.Open(at(tc.now))
+		if open != tc.open {
+			t.Errorf("%v: expected open %v", tc.now, tc.open)
+			continue
+		}
+		got := end
+		if !open {
+			got = cal.NextOpen(at(tc.now))
+		}
+		if !got.Equal(at(tc.expected)) {
+			t.Errorf("%v: expected %v, got %v", tc.now, tc.expected, got.In(chicago))
+		}
+	}
+}
+
+func TestCalendarJoinsWindows(t *testing.T) {
+	morning, _ := ParseWindow("*", "06:00", "12:00", time.UTC)
+	afternoon, _ := ParseWindow("*", "11:00", "18:00", time.UTC)
+	cal := &Calendar{Windows: []*Window{morning, afternoon}}
+	end, open := cal.Open(time.Date(2022, 12, 14, 7, 0, 0, 0, time.UTC))
+	if !open || !end.Equal(time.Date(2022, 12, 14, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the windows to run together until 18:00, got %v %v", open, end)
+	}
+}
+
+func TestLoadHolidays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays")
+	body := "# ercot holidays\n2022-12-26 christmas observed\n\n2023-01-02\n"
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	days, err := LoadHolidays(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 2 || !days["2022-12-26"] || !days["2023-01-02"] {
+		t.Errorf("unexpected holidays %v", days)
+	}
+	if err := os.WriteFile(path, []byte("12/26/2022\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadHolidays(path); err == nil {
+		t.Error("expected a bad date to fail")
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// NewWorker builds the worker a service's config describes, name is the service it runs for
+func NewWorker(name string, cfg *definitions.WorkerConfig) (definitions.Worker, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("no worker configured for %v", name)
+	}
+	index := cfg.Index
+	if index == "" {
+		index = name
+	}
+	switch cfg.Type {
+	case "http_json":
+		wkr, err := NewHTTPJSONWorker(index, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		return wkr, nil
+	case "html_table":
+		wkr, err := NewHTMLTableWorker(index, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		return wkr, nil
+	case "exec":
+		wkr, err := NewExecWorker(index, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		return wkr, nil
+	case "plugin":
+		wkr, err := NewPluginWorker(name, index, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		return wkr, nil
+	case "host_monitor":
+		wkr, err := NewHostWorker(index, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		return wkr, nil
+	default:
+		return nil, fmt.Errorf("unknown worker type %q for %v", cfg.Type, name)
+	}
+}
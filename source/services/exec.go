@@ -0,0 +1,131 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// how much of stderr makes it into an error
+const stderrTail = 1024
+
+// ExecWorker runs a command and makes records of the json it prints, an object, an array
+// or one object per line. a non-zero exit is an error, anything on stderr otherwise is a
+// warning.
+type ExecWorker struct {
+	Index   string
+	Command string
+	Args    []string
+	Env     map[string]string
+	Dir     string
+}
+
+func NewExecWorker(index string, cfg *definitions.WorkerConfig) (*ExecWorker, error) {
+	if cfg.Command == "" {
+		return nil, errors.New("an exec worker needs a command")
+	}
+	if cfg.Dir != "" {
+		if info, err := os.Stat(cfg.Dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("can't run %v in %v, it isn't a directory", cfg.Command, cfg.Dir)
+		}
+	}
+	return &ExecWorker{
+		Index:   index,
+		Command: cfg.Command,
+		Args:    cfg.Args,
+		Env:     cfg.Env,
+		Dir:     cfg.Dir,
+	}, nil
+}
+
+// Collect runs the command until it exits or ctx is done, the service's timeout kills it
+func (w *ExecWorker) Collect(ctx context.Context) (definitions.ZincRecordV2, error) {
+	cmd := exec.Command(w.Command, w.Args...)
+	ownGroup(cmd)
+	cmd.Dir = w.Dir
+	cmd.Env = os.Environ()
+	for k, v := range w.Env {
+		cmd.Env = append(cmd.Env, k+"="+os.ExpandEnv(v))
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	name := filepath.Base(w.Command)
+
+	if err := cmd.Start(); err != nil {
+		return definitions.ZincRecordV2{}, err
+	}
+	// killing just the command could leave what it started holding stdout open, and
+	// wait with it
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = killGroup(cmd)
+		case <-exited:
+		}
+	}()
+	err := cmd.Wait()
+	close(exited)
+	if err != nil {
+		if ctx.Err() != nil {
+			return definitions.ZincRecordV2{}, ctx.Err()
+		}
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return definitions.ZincRecordV2{}, fmt.Errorf("%v exited with %v: %v", name, exit.ExitCode(), tail(stderr.Bytes()))
+		}
+		return definitions.ZincRecordV2{}, err
+	}
+	records, err := decodeRecords(&stdout)
+	if err != nil {
+		return definitions.ZincRecordV2{}, fmt.Errorf("%v printed bad json: %w", name, err)
+	}
+	if len(records) == 0 {
+		return definitions.ZincRecordV2{}, fmt.Errorf("%v printed no records", name)
+	}
+	msg := definitions.ZincRecordV2{
+		Index:   w.Index,
+		Records: records,
+	}
+	if stderr.Len() > 0 {
+		return msg, &definitions.Warning{Err: fmt.Errorf("%v wrote to stderr: %v", name, tail(stderr.Bytes()))}
+	}
+	return msg, nil
+}
+
+// decodeRecords reads json values until r runs out, so a single object, an array and
+// newline delimited objects all work
+func decodeRecords(r io.Reader) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	dec := json.NewDecoder(r)
+	for {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, asRecords(v)...)
+	}
+}
+
+// tail is the end of out, trimmed, where a failing command usually says why
+func tail(out []byte) string {
+	s := strings.TrimSpace(string(out))
+	if len(s) > stderrTail {
+		s = "..." + s[len(s)-stderrTail:]
+	}
+	return s
+}
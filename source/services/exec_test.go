@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+func shell(script string) *definitions.WorkerConfig {
+	return &definitions.WorkerConfig{Type: "exec", Command: "sh", Args: []string{"-c", script}}
+}
+
+func TestExecWorker(t *testing.T) {
+	t.Setenv("RECORDS_TEST_ZONE", "houston")
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		cfg     *definitions.WorkerConfig
+		records int
+	}{
+		{"object", shell(`echo '{"load": 61.5}'`), 1},
+		{"array", shell(`echo '[{"load": 61.5}, {"load": 62}]'`), 2},
+		{"ndjson", shell(`printf '{"load": 61.5}\n\n{"load": 62}\n{"load": 63}\n'`), 3},
+	}
+	for _, tc := range tests {
+		wkr, err := NewWorker("script", tc.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := wkr.Collect(context.Background())
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if msg.Index != "script" || len(msg.Records) != tc.records {
+			t.Errorf("%v: expected %v records, got %v", tc.name, tc.records, msg.Records)
+		}
+	}
+
+	cfg := shell(`printf '{"zone": "%s", "dir": "%s"}' "$ZONE" "$(pwd)"`)
+	cfg.Env = map[string]string{"ZONE": "$RECORDS_TEST_ZONE"}
+	cfg.Dir = dir
+	wkr, err := NewWorker("script", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := wkr.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Records[0]["zone"] != "houston" || !strings.HasSuffix(msg.Records[0]["dir"].(string), dir) {
+		t.Errorf("expected the env and dir to be set, got %v", msg.Records[0])
+	}
+}
+
+func TestExecWorkerFailures(t *testing.T) {
+	wkr, _ := NewWorker("script", shell(`echo '{"load": 61.5}'; echo 'feed is stale' >&2`))
+	msg, err := wkr.Collect(context.Background())
+	var warn *definitions.Warning
+	if !errors.As(err, &warn) || !strings.Contains(err.Error(), "feed is stale") || len(msg.Records) != 1 {
+		t.Errorf("expected the record with a warning, got %v and %v", msg.Records, err)
+	}
+
+	wkr, _ = NewWorker("script", shell(`echo 'no api key' >&2; exit 3`))
+	_, err = wkr.Collect(context.Background())
+	if err == nil || errors.As(err, &warn) || !strings.Contains(err.Error(), "exited with 3: no api key") {
+		t.Errorf("expected the exit code and stderr, got %v", err)
+	}
+
+	wkr, _ = NewWorker("script", shell(`echo 'load=61.5'`))
+	if _, err := wkr.Collect(context.Background()); err == nil {
+		t.Error("expected output that isn't json to fail")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	wkr, _ = NewWorker("script", &definitions.WorkerConfig{Type: "exec", Command: "sleep", Args: []string{"10"}})
+	if _, err := wkr.Collect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the command to be killed at the deadline, got %v", err)
+	}
+	// the shell's sleep holds stdout open, it goes down with the shell
+	wkr, _ = NewWorker("script", shell(`sleep 10; echo '{}'`))
+	start := time.Now()
+	if _, err := wkr.Collect(ctx); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("expected everything the command started to be killed, got %v after %v", err, time.Since(start))
+	}
+
+	if _, err := NewWorker("script", &definitions.WorkerConfig{Type: "exec"}); err == nil {
+		t.Error("expected a missing command to fail")
+	}
+	if _, err := NewWorker("script", &definitions.WorkerConfig{Type: "exec", Command: "true", Dir: "/no/such/dir"}); err == nil {
+		t.Error("expected a missing dir to fail")
+	}
+}
@@ -1,54 +1,21 @@
 package services
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/schedule"
 	"golang.org/x/net/html"
 )
 
-func SaveRecordToZinc(zuri string, record definitions.ZincRecordV2, logger *log.Logger) {
-	record.Index = fmt.Sprintf("%v-%v", time.Now().Format("200601"), record.Index)
-	out, err := json.Marshal(record)
-	if err != nil {
-		logger.Println(err)
-		return
-	}
-	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodPost, zuri, bytes.NewBuffer([]byte(out)))
-	req.Header.Set("Content-Type", "application/json")
-	// ideally we'd be storing secrets in a secrets manager, this is for dev purposes
-	req.Header.Add("Authorization", "Basic "+basicAuth("admin", os.Getenv("ZINC_API_PWD")))
-	if err != nil {
-		logger.Println(err)
-		return
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		logger.Println("http client failure", err)
-		return
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		logger.Println("got an unexpected status code", res.StatusCode)
-		return
-	}
-}
-
-func basicAuth(username, password string) string {
-	auth := username + ":" + password
-	return base64.StdEncoding.EncodeToString([]byte(auth))
-}
+// Clock is where the workers get the time from
+var Clock schedule.Clock = schedule.System
 
 const (
 	CurrentFrequency = iota
@@ -68,12 +35,27 @@ const (
 )
 
 const (
-	ErcotRTSC  = "https://www.ercot.com/content/cdr/html/real_time_system_conditions.html"
-	ErcotSPP   = "https://www.ercot.com/content/cdr/html/real_time_spp.html"
+	ErcotRTSC = "https://www.ercot.com/content/cdr/html/real_time_system_conditions.html"
+	ErcotSPP  = "https://www.ercot.com/content/cdr/html/real_time_spp.html"
+	// a day's prices, by YYYYMMDD
+	ErcotHistoricalSPP = "https://www.ercot.com/content/cdr/html/%v_real_time_spp.html"
+	// operating days and intervals are in central time
+	ErcotZone  = "America/Chicago"
 	WeatherUri = "http://api.weatherapi.com/v1/current.json?key=&q=%v"
 	ZincUri    = "http://127.0.0.1:4080/api/_bulkv2"
 )
 
+// hostsOf returns the host of each url
+func hostsOf(urls ...string) []string {
+	var hosts []string
+	for _, raw := range urls {
+		if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
 type Pair[T, U any] struct {
 	Key   T
 	Value U
@@ -168,9 +150,9 @@ type CpuValue struct {
 // GetCpuValues reads the proc stat file, waits for the refresh
 // interval and returns the list of values
 func GetCpuValues(c chan []*CpuValue, refresh int) {
-	now := time.Now()
+	now := Clock.Now()
 	values := []*CpuValue{}
-	initialPoll, err := pollCpu()
+	initialPoll, err := pollCpu("/proc")
 	keys := make([]string, 0, len(initialPoll))
 	for k := range initialPoll {
 		keys = append(keys, k)
@@ -179,8 +161,8 @@ func GetCpuValues(c chan []*CpuValue, refresh int) {
 	if err != nil {
 		log.Println(err)
 	}
-	time.Sleep(time.Duration(refresh) * time.Second)
-	poll, err := pollCpu()
+	Clock.Sleep(time.Duration(refresh) * time.Second)
+	poll, err := pollCpu("/proc")
 	if err != nil {
 		log.Println(err)
 	}
@@ -195,12 +177,11 @@ func GetCpuValues(c chan []*CpuValue, refresh int) {
 	c <- values
 }
 
-// pollCpu reads the /proc/stat file and calculates cpu utilization percentage.
+// pollCpu reads the stat file under the proc root and calculates cpu utilization percentage.
 // returns a map of cpu stats where: map[cpuN] = n%
-func pollCpu() (map[string]*Usage, error) {
+func pollCpu(root string) (map[string]*Usage, error) {
 	usage := make(map[string]*Usage)
-	result := &Usage{}
-	contents, err := os.ReadFile("/proc/stat")
+	contents, err := os.ReadFile(filepath.Join(root, "stat"))
 	if err != nil {
 		return usage, err
 	}
@@ -212,6 +193,8 @@ func pollCpu() (map[string]*Usage, error) {
 			continue
 		}
 		if strings.Contains(fields[0], "cpu") {
+			// each cpu gets its own totals
+			result := &Usage{}
 			nFields := len(fields)
 			for i := 1; i < nFields; i++ {
 				if i == 4 {
@@ -0,0 +1,520 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// the host monitor's subsystems, in the order their records come out
+var hostSubsystems = []string{"cpu", "memory", "load", "network", "disk", "mounts"}
+
+// statfs returns the total, free and available bytes of the filesystem at path, tests
+// swap it out since fixture mounts don't exist
+var statfs = diskUsage
+
+// HostWorker reads the host's cpu, memory, load, network, disk and mount usage out of
+// /proc, a record per subsystem. rates are worked out against the previous tick.
+type HostWorker struct {
+	Index      string
+	Root       string
+	Subsystems map[string]bool
+	Mounts     []string
+
+	mtx  sync.Mutex
+	last *hostCounters
+}
+
+// hostCounters are the counters a tick saw, for the next tick's rates
+type hostCounters struct {
+	at   time.Time
+	cpu  map[string]cpuTimes
+	net  map[string]netCounters
+	disk map[string]diskCounters
+}
+
+type cpuTimes struct {
+	user, nice, system, idle, iowait, irq, softirq, steal uint64
+}
+
+// total leaves out guest time, which is already counted in user
+func (c cpuTimes) total() uint64 {
+	return c.user + c.nice + c.system + c.idle + c.iowait + c.irq + c.softirq + c.steal
+}
+
+type netCounters struct {
+	rxBytes, rxPackets, rxErrors, rxDrops uint64
+	txBytes, txPackets, txErrors, txDrops uint64
+}
+
+type diskCounters struct {
+	reads, readSectors, writes, writeSectors, ioMillis uint64
+}
+
+func NewHostWorker(index string, cfg *definitions.WorkerConfig) (*HostWorker, error) {
+	root := cfg.ProcRoot
+	if root == "" {
+		root = "/proc"
+	}
+	subsystems := make(map[string]bool)
+	for _, s := range cfg.Subsystems {
+		known := false
+		for _, h := range hostSubsystems {
+			known = known || s == h
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown subsystem %q, expected one of %v", s, strings.Join(hostSubsystems, ", "))
+		}
+		subsystems[s] = true
+	}
+	if len(subsystems) == 0 {
+		for _, h := range hostSubsystems {
+			subsystems[h] = true
+		}
+	}
+	return &HostWorker{
+		Index:      index,
+		Root:       root,
+		Subsystems: subsystems,
+		Mounts:     cfg.Mounts,
+	}, nil
+}
+
+// Collect reports every subsystem it can, one that can't be read is a warning unless none
+// of them can
+func (w *HostWorker) Collect(ctx context.Context) (definitions.ZincRecordV2, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	host, _ := os.Hostname()
+	now := Clock.Now()
+	cur := &hostCounters{at: now}
+	var records []map[string]interface{}
+	var errs []string
+	for _, sub := range hostSubsystems {
+		if !w.Subsystems[sub] {
+			continue
+		}
+		base := definitions.HostSample{Subsystem: sub, Host: host, Time: now}
+		var rec interface{}
+		var err error
+		switch sub {
+		case "cpu":
+			rec, err = w.cpu(base, cur)
+		case "memory":
+			rec, err = w.memory(base)
+		case "load":
+			rec, err = w.load(base)
+		case "network":
+			rec, err = w.network(base, cur)
+		case "disk":
+			rec, err = w.disk(base, cur)
+		case "mounts":
+			rec, err = w.mounts(base)
+		}
+		var warn *definitions.Warning
+		if errors.As(err, &warn) {
+			// the record is still good, without what the warning is about
+			errs = append(errs, fmt.Sprintf("%v: %v", sub, err))
+			err = nil
+		}
+		if err == nil {
+			var m map[string]interface{}
+			if m, err = toMap(rec); err == nil {
+				records = append(records, m)
+				continue
+			}
+		}
+		errs = append(errs, fmt.Sprintf("%v: %v", sub, err))
+	}
+	w.last = cur
+	if len(records) == 0 {
+		return definitions.ZincRecordV2{}, fmt.Errorf("no host stats read from %v: %v", w.Root, strings.Join(errs, "; "))
+	}
+	msg := definitions.ZincRecordV2{
+		Index:   w.Index,
+		Records: records,
+	}
+	if len(errs) > 0 {
+		return msg, &definitions.Warning{Err: fmt.Errorf("host stats missing %v", strings.Join(errs, "; "))}
+	}
+	return msg, nil
+}
+
+// since is the seconds from the previous tick, zero if it didn't see the subsystem
+func (w *HostWorker) since(now time.Time, seen bool) float64 {
+	if w.last == nil || !seen {
+		return 0
+	}
+	return now.Sub(w.last.at).Seconds()
+}
+
+func (w *HostWorker) cpu(base definitions.HostSample, cur *hostCounters) (*definitions.HostCpu, error) {
+	times, names, err := readCPU(w.Root)
+	if err != nil {
+		return nil, err
+	}
+	cur.cpu = times
+	var prev map[string]cpuTimes
+	if w.last != nil {
+		prev = w.last.cpu
+	}
+	base.Interval = w.since(base.Time, prev != nil)
+	// without a previous tick the counters since boot are the delta
+	diff := func(name string) cpuTimes {
+		c, p := times[name], prev[name]
+		return cpuTimes{
+			user:    delta(p.user, c.user),
+			nice:    delta(p.nice, c.nice),
+			system:  delta(p.system, c.system),
+			idle:    delta(p.idle, c.idle),
+			iowait:  delta(p.iowait, c.iowait),
+			irq:     delta(p.irq, c.irq),
+			softirq: delta(p.softirq, c.softirq),
+			steal:   delta(p.steal, c.steal),
+		}
+	}
+	all := diff("cpu")
+	total := all.total()
+	rec := &definitions.HostCpu{
+		HostSample: base,
+		Usage:      percent(total-all.idle-all.iowait, total),
+		User:       percent(all.user+all.nice, total),
+		System:     percent(all.system+all.irq+all.softirq, total),
+		Iowait:     percent(all.iowait, total),
+		Steal:      percent(all.steal, total),
+	}
+	for _, name := range names {
+		if name == "cpu" {
+			continue
+		}
+		d := diff(name)
+		rec.Cpus = append(rec.Cpus, &definitions.HostCpuUsage{
+			Name:  name,
+			Usage: percent(d.total()-d.idle-d.iowait, d.total()),
+		})
+	}
+	return rec, nil
+}
+
+func (w *HostWorker) memory(base definitions.HostSample) (*definitions.HostMemory, error) {
+	data, err := os.ReadFile(filepath.Join(w.Root, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	kb := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad meminfo line %q", line)
+		}
+		kb[strings.TrimSuffix(fields[0], ":")] = v * 1024
+	}
+	if _, ok := kb["MemTotal"]; !ok {
+		return nil, fmt.Errorf("no MemTotal in %v", filepath.Join(w.Root, "meminfo"))
+	}
+	available, ok := kb["MemAvailable"]
+	if !ok {
+		// kernels before 3.14 don't work it out
+		available = kb["MemFree"] + kb["Buffers"] + kb["Cached"]
+	}
+	used := delta(available, kb["MemTotal"])
+	return &definitions.HostMemory{
+		HostSample:  base,
+		Total:       kb["MemTotal"],
+		Free:        kb["MemFree"],
+		Available:   available,
+		Used:        used,
+		UsedPercent: percent(used, kb["MemTotal"]),
+		Buffers:     kb["Buffers"],
+		Cached:      kb["Cached"],
+		SwapTotal:   kb["SwapTotal"],
+		SwapFree:    kb["SwapFree"],
+		SwapUsed:    delta(kb["SwapFree"], kb["SwapTotal"]),
+	}, nil
+}
+
+// load reads a line like `0.52 0.58 0.59 2/1034 12345`
+func (w *HostWorker) load(base definitions.HostSample) (*definitions.HostLoad, error) {
+	data, err := os.ReadFile(filepath.Join(w.Root, "loadavg"))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("bad loadavg %q", strings.TrimSpace(string(data)))
+	}
+	rec := &definitions.HostLoad{HostSample: base}
+	var errs [5]error
+	rec.Load1, errs[0] = strconv.ParseFloat(fields[0], 64)
+	rec.Load5, errs[1] = strconv.ParseFloat(fields[1], 64)
+	rec.Load15, errs[2] = strconv.ParseFloat(fields[2], 64)
+	running, processes, _ := strings.Cut(fields[3], "/")
+	rec.Running, errs[3] = strconv.Atoi(running)
+	rec.Processes, errs[4] = strconv.Atoi(processes)
+	for _, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("bad loadavg %q", strings.TrimSpace(string(data)))
+		}
+	}
+	return rec, nil
+}
+
+func (w *HostWorker) network(base definitions.HostSample, cur *hostCounters) (*definitions.HostNetwork, error) {
+	counters, names, err := readNet(w.Root)
+	if err != nil {
+		return nil, err
+	}
+	cur.net = counters
+	var prev map[string]netCounters
+	if w.last != nil {
+		prev = w.last.net
+	}
+	base.Interval = w.since(base.Time, prev != nil)
+	rec := &definitions.HostNetwork{HostSample: base, Interfaces: []*definitions.HostInterface{}}
+	for _, name := range names {
+		c := counters[name]
+		iface := &definitions.HostInterface{
+			Name:      name,
+			RxBytes:   c.rxBytes,
+			TxBytes:   c.txBytes,
+			RxPackets: c.rxPackets,
+			TxPackets: c.txPackets,
+			RxErrors:  c.rxErrors,
+			TxErrors:  c.txErrors,
+			RxDrops:   c.rxDrops,
+			TxDrops:   c.txDrops,
+		}
+		if p, ok := prev[name]; ok && base.Interval > 0 {
+			iface.RxBytesPerSec = rate(p.rxBytes, c.rxBytes, base.Interval)
+			iface.TxBytesPerSec = rate(p.txBytes, c.txBytes, base.Interval)
+			iface.RxPacketsPerSec = rate(p.rxPackets, c.rxPackets, base.Interval)
+			iface.TxPacketsPerSec = rate(p.txPackets, c.txPackets, base.Interval)
+		}
+		rec.Interfaces = append(rec.Interfaces, iface)
+	}
+	return rec, nil
+}
+
+func (w *HostWorker) disk(base definitions.HostSample, cur *hostCounters) (*definitions.HostDisks, error) {
+	counters, names, err := readDisks(w.Root)
+	if err != nil {
+		return nil, err
+	}
+	cur.disk = counters
+	var prev map[string]diskCounters
+	if w.last != nil {
+		prev = w.last.disk
+	}
+	base.Interval = w.since(base.Time, prev != nil)
+	rec := &definitions.HostDisks{HostSample: base, Devices: []*definitions.HostDisk{}}
+	for _, name := range names {
+		c := counters[name]
+		d := &definitions.HostDisk{
+			Name:       name,
+			Reads:      c.reads,
+			Writes:     c.writes,
+			ReadBytes:  c.readSectors * 512,
+			WriteBytes: c.writeSectors * 512,
+		}
+		if p, ok := prev[name]; ok && base.Interval > 0 {
+			d.ReadsPerSec = rate(p.reads, c.reads, base.Interval)
+			d.WritesPerSec = rate(p.writes, c.writes, base.Interval)
+			// sectors are always 512 bytes here, whatever the device's are
+			d.ReadBytesPerSec = rate(p.readSectors, c.readSectors, base.Interval) * 512
+			d.WriteBytesPerSec = rate(p.writeSectors, c.writeSectors, base.Interval) * 512
+			d.BusyPercent = percent(delta(p.ioMillis, c.ioMillis), uint64(base.Interval*1000))
+		}
+		rec.Devices = append(rec.Devices, d)
+	}
+	return rec, nil
+}
+
+func (w *HostWorker) mounts(base definitions.HostSample) (*definitions.HostMounts, error) {
+	data, err := os.ReadFile(filepath.Join(w.Root, "mounts"))
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, m := range w.Mounts {
+		wanted[m] = true
+	}
+	rec := &definitions.HostMounts{HostSample: base, Mounts: []*definitions.HostMount{}}
+	seen := make(map[string]bool)
+	var skipped []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		device, path, fsType := unescapeMount(fields[0]), unescapeMount(fields[1]), fields[2]
+		if seen[path] {
+			continue
+		}
+		// without a list of mounts, just the filesystems on block devices
+		if (len(wanted) > 0 && !wanted[path]) || (len(wanted) == 0 && !strings.HasPrefix(device, "/")) {
+			continue
+		}
+		seen[path] = true
+		total, free, available, err := statfs(path)
+		if err != nil {
+			// one mount that can't be read, a stale nfs one say, doesn't hide the rest
+			skipped = append(skipped, fmt.Sprintf("%v: %v", path, err))
+			continue
+		}
+		used := delta(free, total)
+		rec.Mounts = append(rec.Mounts, &definitions.HostMount{
+			Path:      path,
+			Device:    device,
+			FsType:    fsType,
+			Total:     total,
+			Free:      free,
+			Available: available,
+			Used:      used,
+			// what df shows, the root reserved blocks don't count as room
+			UsedPercent: percent(used, used+available),
+		})
+	}
+	for _, m := range w.Mounts {
+		if !seen[m] {
+			return nil, fmt.Errorf("%v isn't mounted", m)
+		}
+	}
+	if len(skipped) > 0 {
+		return rec, &definitions.Warning{Err: errors.New(strings.Join(skipped, "; "))}
+	}
+	return rec, nil
+}
+
+// readCPU reads the cpu lines of stat, the total as "cpu" and each cpu by name
+func readCPU(root string) (map[string]cpuTimes, []string, error) {
+	data, err := os.ReadFile(filepath.Join(root, "stat"))
+	if err != nil {
+		return nil, nil, err
+	}
+	times := make(map[string]cpuTimes)
+	var names []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		// older kernels have fewer columns, the missing ones stay zero
+		var v [8]uint64
+		for i := 1; i < len(fields) && i <= len(v); i++ {
+			if v[i-1], err = strconv.ParseUint(fields[i], 10, 64); err != nil {
+				return nil, nil, fmt.Errorf("bad stat line %q", sc.Text())
+			}
+		}
+		times[fields[0]] = cpuTimes{v[0], v[1], v[2], v[3], v[4], v[5], v[6], v[7]}
+		names = append(names, fields[0])
+	}
+	if _, ok := times["cpu"]; !ok {
+		return nil, nil, fmt.Errorf("no cpu line in %v", filepath.Join(root, "stat"))
+	}
+	return times, names, nil
+}
+
+// readNet reads net/dev, where a line is `eth0: rx bytes packets errs drop ... tx bytes ...`
+func readNet(root string) (map[string]netCounters, []string, error) {
+	data, err := os.ReadFile(filepath.Join(root, "net", "dev"))
+	if err != nil {
+		return nil, nil, err
+	}
+	counters := make(map[string]netCounters)
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		name, rest, ok := strings.Cut(line, ":")
+		// the two header lines have no colon
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 16 {
+			return nil, nil, fmt.Errorf("bad net/dev line %q", line)
+		}
+		var v [16]uint64
+		for i := range v {
+			if v[i], err = strconv.ParseUint(fields[i], 10, 64); err != nil {
+				return nil, nil, fmt.Errorf("bad net/dev line %q", line)
+			}
+		}
+		name = strings.TrimSpace(name)
+		counters[name] = netCounters{
+			rxBytes: v[0], rxPackets: v[1], rxErrors: v[2], rxDrops: v[3],
+			txBytes: v[8], txPackets: v[9], txErrors: v[10], txDrops: v[11],
+		}
+		names = append(names, name)
+	}
+	return counters, names, nil
+}
+
+// readDisks reads diskstats, leaving out loop and ram devices
+func readDisks(root string) (map[string]diskCounters, []string, error) {
+	data, err := os.ReadFile(filepath.Join(root, "diskstats"))
+	if err != nil {
+		return nil, nil, err
+	}
+	counters := make(map[string]diskCounters)
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 13 {
+			return nil, nil, fmt.Errorf("bad diskstats line %q", line)
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		var v [10]uint64
+		for i := range v {
+			if v[i], err = strconv.ParseUint(fields[i+3], 10, 64); err != nil {
+				return nil, nil, fmt.Errorf("bad diskstats line %q", line)
+			}
+		}
+		counters[name] = diskCounters{reads: v[0], readSectors: v[2], writes: v[4], writeSectors: v[6], ioMillis: v[9]}
+		names = append(names, name)
+	}
+	return counters, names, nil
+}
+
+// unescapeMount undoes the octal escapes mounts uses for spaces and the like
+func unescapeMount(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(s)
+}
+
+// delta is how much a counter grew, zero if it was reset
+func delta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+func rate(prev, cur uint64, seconds float64) float64 {
+	return float64(delta(prev, cur)) / seconds
+}
+
+func percent(part, whole uint64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/schedule"
+)
+
+// hostRecords collects once and keys the records by subsystem
+func hostRecords(t *testing.T, wkr *HostWorker) map[string]map[string]interface{} {
+	t.Helper()
+	msg, err := wkr.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]map[string]interface{})
+	for _, r := range msg.Records {
+		out[r["subsystem"].(string)] = r
+	}
+	return out
+}
+
+// field follows keys and array indexes through a record
+func field(t *testing.T, v interface{}, path ...interface{}) interface{} {
+	t.Helper()
+	for _, p := range path {
+		switch key := p.(type) {
+		case string:
+			v = v.(map[string]interface{})[key]
+		case int:
+			v = v.([]interface{})[key]
+		}
+	}
+	return v
+}
+
+func near(got interface{}, expected float64) bool {
+	f, ok := got.(float64)
+	return ok && math.Abs(f-expected) < 1e-9
+}
+
+func TestHostWorker(t *testing.T) {
+	clock := schedule.NewFakeClock(time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	previous, previousStatfs := Clock, statfs
+	Clock = clock
+	statfs = func(path string) (uint64, uint64, uint64, error) {
+		if path == "/mnt/my data" {
+			return 1000, 400, 300, nil
+		}
+		return 2000, 1000, 900, nil
+	}
+	t.Cleanup(func() { Clock, statfs = previous, previousStatfs })
+
+	wkr, err := NewHostWorker("host", &definitions.WorkerConfig{ProcRoot: filepath.Join("testdata", "proc", "tick1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := hostRecords(t, wkr)
+	if len(first) != len(hostSubsystems) {
+		t.Fatalf("expected a record per subsystem, got %v", first)
+	}
+	// no previous tick, cpu is since boot and there are no rates
+	cpu := first["cpu"]
+	if cpu["interval"] != 0.0 || !near(cpu["usage"], 100*1500.0/9600) {
+		t.Errorf("expected cpu since boot, got %v", cpu)
+	}
+	if eth0 := field(t, first["network"], "interfaces", 1); eth0.(map[string]interface{})["rx_bytes_per_sec"] != 0.0 {
+		t.Errorf("expected no rates on the first tick, got %v", eth0)
+	}
+
+	clock.Advance(10 * time.Second)
+	wkr.Root = filepath.Join("testdata", "proc", "tick2")
+	second := hostRecords(t, wkr)
+
+	cpu = second["cpu"]
+	if cpu["interval"] != 10.0 || !near(cpu["usage"], 40) || !near(cpu["iowait"], 10) {
+		t.Errorf("expected 40%% busy and 10%% iowait, got %v", cpu)
+	}
+	// each cpu counts on its own
+	if !near(field(t, cpu, "cpus", 0, "usage"), 60) || !near(field(t, cpu, "cpus", 1, "usage"), 20) {
+		t.Errorf("expected cpu0 at 60%% and cpu1 at 20%%, got %v", cpu["cpus"])
+	}
+
+	mem := second["memory"]
+	if mem["total"] != 16384000000.0 || mem["used"] != 8192000000.0 || !near(mem["used_percent"], 50) || mem["swap_used"] != 512000000.0 {
+		t.Errorf("unexpected memory %v", mem)
+	}
+	load := second["load"]
+	if load["load1"] != 0.52 || load["running"] != 2.0 || load["processes"] != 1034.0 {
+		t.Errorf("unexpected load %v", load)
+	}
+
+	eth0 := field(t, second["network"], "interfaces", 1).(map[string]interface{})
+	if eth0["name"] != "eth0" || !near(eth0["rx_bytes_per_sec"], 10000) || !near(eth0["tx_bytes_per_sec"], 2000) ||
+		!near(eth0["rx_packets_per_sec"], 50) || !near(eth0["tx_packets_per_sec"], 20) || eth0["rx_drops"] != 2.0 {
+		t.Errorf("unexpected eth0 %v", eth0)
+	}
+
+	devices := field(t, second["disk"], "devices").([]interface{})
+	sda := devices[0].(map[string]interface{})
+	if len(devices) != 2 || sda["name"] != "sda" {
+		t.Fatalf("expected sda and sda1 without loop0, got %v", devices)
+	}
+	if !near(sda["reads_per_sec"], 10) || !near(sda["writes_per_sec"], 50) || !near(sda["read_bytes_per_sec"], 1024000) ||
+		!near(sda["write_bytes_per_sec"], 2048000) || !near(sda["busy_percent"], 25) {
+		t.Errorf("unexpected sda %v", sda)
+	}
+
+	mounts := field(t, second["mounts"], "mounts").([]interface{})
+	if len(mounts) != 2 {
+		t.Fatalf("expected the two block device mounts, got %v", mounts)
+	}
+	data := mounts[1].(map[string]interface{})
+	if data["path"] != "/mnt/my data" || data["fs_type"] != "xfs" || data["used"] != 600.0 || !near(data["used_percent"], 200.0/3) {
+		t.Errorf("unexpected mount %v", data)
+	}
+}
+
+func TestHostWorkerSubsystems(t *testing.T) {
+	root := t.TempDir()
+	stat, err := os.ReadFile(filepath.Join("testdata", "proc", "tick1", "stat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "stat"), stat, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wkr, err := NewHostWorker("host", &definitions.WorkerConfig{ProcRoot: root, Subsystems: []string{"cpu", "memory"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// memory can't be read, cpu still comes through
+	msg, err := wkr.Collect(context.Background())
+	var warn *definitions.Warning
+	if !errors.As(err, &warn) || !strings.Contains(err.Error(), "memory") || len(msg.Records) != 1 {
+		t.Errorf("expected the cpu record and a warning about memory, got %v and %v", msg.Records, err)
+	}
+
+	wkr, _ = NewHostWorker("host", &definitions.WorkerConfig{ProcRoot: root, Subsystems: []string{"load"}})
+	if _, err := wkr.Collect(context.Background()); err == nil || errors.As(err, &warn) {
+		t.Errorf("expected nothing read to fail, got %v", err)
+	}
+	if _, err := NewHostWorker("host", &definitions.WorkerConfig{Subsystems: []string{"gpu"}}); err == nil {
+		t.Error("expected an unknown subsystem to fail")
+	}
+}
+
+func TestHostWorkerMountFails(t *testing.T) {
+	previous := statfs
+	statfs = func(path string) (uint64, uint64, uint64, error) {
+		if path == "/mnt/my data" {
+			return 0, 0, 0, errors.New("stale file handle")
+		}
+		return 2000, 1000, 900, nil
+	}
+	t.Cleanup(func() { statfs = previous })
+
+	wkr, err := NewHostWorker("host", &definitions.WorkerConfig{ProcRoot: filepath.Join("testdata", "proc", "tick1"), Subsystems: []string{"mounts"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mount that can't be read is left out, the rest still come through
+	msg, err := wkr.Collect(context.Background())
+	var warn *definitions.Warning
+	if !errors.As(err, &warn) || !strings.Contains(err.Error(), "/mnt/my data: stale file handle") {
+		t.Errorf("expected a warning about the stale mount, got %v", err)
+	}
+	if len(msg.Records) != 1 {
+		t.Fatalf("expected the mounts record, got %v", msg.Records)
+	}
+	if mounts := field(t, msg.Records[0], "mounts").([]interface{}); len(mounts) != 1 || field(t, mounts[0], "path") != "/" {
+		t.Errorf("expected just the root mount, got %v", mounts)
+	}
+}
+
+func Test_pollCpu(t *testing.T) {
+	usage, err := pollCpu(filepath.Join("testdata", "proc", "tick1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage["cpu0"] == usage["cpu1"] || usage["cpu0"].Total != 4850 || usage["cpu1"].Idle != 4100 || usage["cpu"].Total != 9600 {
+		t.Errorf("expected each cpu to have its own totals, got cpu %+v cpu0 %+v cpu1 %+v", usage["cpu"], usage["cpu0"], usage["cpu1"])
+	}
+}
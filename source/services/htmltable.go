@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/rexlx/records/source/definitions"
+	"golang.org/x/net/html"
+)
+
+const (
+	rowsLast = "last"
+	rowsAll  = "all"
+	rowsNew  = "new"
+)
+
+// HTMLTableWorker scrapes a table off a page, a record per row it selects
+type HTMLTableWorker struct {
+	Index     string
+	Url       string
+	Table     cascadia.Sel
+	HeaderRow int
+	Columns   []*definitions.TableColumn
+	Rows      string
+	Key       string
+	Loc       *time.Location
+
+	mtx sync.Mutex
+	// key of the newest row handed out, for rows new
+	seen interface{}
+}
+
+func NewHTMLTableWorker(index string, cfg *definitions.WorkerConfig) (*HTMLTableWorker, error) {
+	if cfg.Url == "" {
+		return nil, errors.New("an html_table worker needs a url")
+	}
+	table := cfg.Table
+	if table == "" {
+		table = "table"
+	}
+	sel, err := cascadia.Parse(table)
+	if err != nil {
+		return nil, fmt.Errorf("bad table selector %q: %w", table, err)
+	}
+	rows := cfg.Rows
+	switch rows {
+	case "":
+		rows = rowsLast
+	case rowsLast, rowsAll:
+	case rowsNew:
+		if cfg.Key == "" {
+			return nil, errors.New("rows new needs a key field to tell rows apart")
+		}
+	default:
+		return nil, fmt.Errorf("unknown rows %q, expected %v, %v or %v", cfg.Rows, rowsLast, rowsAll, rowsNew)
+	}
+	loc := time.Local
+	if cfg.TimeZone != "" {
+		if loc, err = time.LoadLocation(cfg.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+	keyed := cfg.Key == ""
+	for _, c := range cfg.Columns {
+		if c.Field == "" || (c.Header == "" && c.Position < 1) {
+			return nil, fmt.Errorf("column %+v needs a field and a header or position", *c)
+		}
+		switch c.Type {
+		case "", "string", "float", "int", "bool":
+		case "time":
+			if c.Format == "" {
+				return nil, fmt.Errorf("time column %v needs a format", c.Field)
+			}
+		default:
+			return nil, fmt.Errorf("unknown type %q for column %v", c.Type, c.Field)
+		}
+		keyed = keyed || c.Field == cfg.Key
+	}
+	if len(cfg.Columns) > 0 && !keyed {
+		return nil, fmt.Errorf("key %v isn't one of the columns", cfg.Key)
+	}
+	return &HTMLTableWorker{
+		Index:     index,
+		Url:       cfg.Url,
+		Table:     sel,
+		HeaderRow: cfg.HeaderRow,
+		Columns:   cfg.Columns,
+		Rows:      rows,
+		Key:       cfg.Key,
+		Loc:       loc,
+	}, nil
+}
+
+func (w *HTMLTableWorker) Collect(ctx context.Context) (definitions.ZincRecordV2, error) {
+	doc, err := getHTML(ctx, w.Url)
+	if err != nil {
+		return definitions.ZincRecordV2{}, err
+	}
+	rows, err := w.parse(doc)
+	if err != nil {
+		return definitions.ZincRecordV2{}, fmt.Errorf("%v: %w", w.Url, err)
+	}
+	if len(rows) == 0 {
+		return definitions.ZincRecordV2{}, fmt.Errorf("no rows found at %v", w.Url)
+	}
+	records, err := w.pick(rows)
+	if err != nil {
+		return definitions.ZincRecordV2{}, err
+	}
+	return definitions.ZincRecordV2{
+		Index:   w.Index,
+		Records: records,
+	}, nil
+}
+
+// parse turns the table's data rows into records, rows too short for the columns are
+// left out
+func (w *HTMLTableWorker) parse(doc *html.Node) ([]map[string]interface{}, error) {
+	table := cascadia.Query(doc, w.Table)
+	if table == nil {
+		return nil, fmt.Errorf("no table matches %v", w.Table.String())
+	}
+	rows := tableRows(table)
+	var header []string
+	switch {
+	case w.HeaderRow > 0:
+		if w.HeaderRow > len(rows) {
+			return nil, fmt.Errorf("no header row %v in a table of %v rows", w.HeaderRow, len(rows))
+		}
+		header = cellText(rows[w.HeaderRow-1])
+		rows = rows[w.HeaderRow:]
+	case w.HeaderRow == 0 && len(rows) > 0 && headerRow(rows[0]):
+		header = cellText(rows[0])
+		rows = rows[1:]
+	}
+
+	columns := w.Columns
+	if len(columns) == 0 {
+		if header == nil {
+			return nil, errors.New("a table without a header row needs columns")
+		}
+		for _, h := range header {
+			columns = append(columns, &definitions.TableColumn{Header: h, Field: h})
+		}
+	}
+	positions := make([]int, len(columns))
+	width := 0
+	for i, c := range columns {
+		positions[i] = c.Position - 1
+		if c.Position < 1 {
+			positions[i] = indexOf(header, c.Header)
+			if positions[i] < 0 {
+				return nil, fmt.Errorf("no column headed %q", c.Header)
+			}
+		}
+		if positions[i] >= width {
+			width = positions[i] + 1
+		}
+	}
+
+	var out []map[string]interface{}
+	for n, row := range rows {
+		cells := cellText(row)
+		if len(cells) < width {
+			continue
+		}
+		record := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			v, err := coerce(cells[positions[i]], c, w.Loc)
+			if err != nil {
+				return nil, fmt.Errorf("row %v, %v: %w", n+1, c.Field, err)
+			}
+			if v != nil {
+				record[c.Field] = v
+			}
+		}
+		out = append(out, record)
+	}
+	return out, nil
+}
+
+// pick selects the rows to report, rows new reports what came after the last row seen, by
+// time when the key is a time and by position otherwise. the first run reports them all.
+func (w *HTMLTableWorker) pick(rows []map[string]interface{}) ([]map[string]interface{}, error) {
+	switch w.Rows {
+	case rowsAll:
+		return rows, nil
+	case rowsNew:
+	default:
+		return rows[len(rows)-1:], nil
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	fresh := rows
+	switch seen := w.seen.(type) {
+	case nil:
+	case time.Time:
+		fresh = nil
+		for _, r := range rows {
+			if at, ok := r[w.Key].(time.Time); ok && at.After(seen) {
+				fresh = append(fresh, r)
+			}
+		}
+	default:
+		// a table that no longer has the row seen has rolled over, it is all new
+		for i := len(rows) - 1; i >= 0; i-- {
+			if rows[i][w.Key] == seen {
+				fresh = rows[i+1:]
+				break
+			}
+		}
+	}
+	if len(fresh) == 0 {
+		return nil, definitions.ErrNothingNew
+	}
+	newest := fresh[len(fresh)-1][w.Key]
+	if at, ok := newest.(time.Time); ok {
+		for _, r := range fresh {
+			if t, ok := r[w.Key].(time.Time); ok && t.After(at) {
+				at = t
+			}
+		}
+		newest = at
+	}
+	w.seen = newest
+	return fresh, nil
+}
+
+// coerce converts a cell to its column's type, an empty cell is nil
+func coerce(cell string, c *definitions.TableColumn, loc *time.Location) (interface{}, error) {
+	if cell == "" {
+		return nil, nil
+	}
+	number := strings.NewReplacer(",", "", "$", "", "%", "").Replace(cell)
+	switch c.Type {
+	case "float":
+		return strconv.ParseFloat(number, 64)
+	case "int":
+		return strconv.ParseInt(number, 10, 64)
+	case "bool":
+		return strconv.ParseBool(strings.ToLower(cell))
+	case "time":
+		return time.ParseInLocation(c.Format, cell, loc)
+	}
+	return cell, nil
+}
+
+// tableRows is every row of table, but not of the tables inside it
+func tableRows(table *html.Node) []*html.Node {
+	var rows []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "tr":
+				rows = append(rows, child)
+			case "thead", "tbody", "tfoot":
+				walk(child)
+			}
+		}
+	}
+	walk(table)
+	return rows
+}
+
+// headerRow is true for a row made only of <th>
+func headerRow(row *html.Node) bool {
+	found := false
+	for child := row.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		if child.Data != "th" {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// cellText is the text of each cell in a row, with the whitespace tidied
+func cellText(row *html.Node) []string {
+	var cells []string
+	for child := row.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && (child.Data == "td" || child.Data == "th") {
+			cells = append(cells, strings.Join(strings.Fields(text(child)), " "))
+		}
+	}
+	return cells
+}
+
+func text(n *html.Node) string {
+	switch {
+	case n.Type == html.TextNode:
+		return n.Data
+	case n.Type == html.ElementNode && n.Data == "br":
+		return " "
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(text(child))
+	}
+	return b.String()
+}
+
+func indexOf(header []string, name string) int {
+	for i, h := range header {
+		if strings.EqualFold(h, strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// sppPage is laid out like ERCOT's real time prices, rows grows by one on every fetch
+func sppPage(rows int) string {
+	var b strings.Builder
+	b.WriteString(`<html><body><table class="legend"><tr><td>not this one</td></tr></table>
+<table class="tableStyle"><tr><th class="headerValueClass">Oper Day</th><th class="headerValueClass">Interval Ending</th><th class="headerValueClass">HB_HOUSTON</th><th class="headerValueClass">LZ_WEST</th></tr>`)
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&b, `<tr><td class="labelClassCenter">12/14/2022</td><td class="labelClassCenter">%04d</td><td class="labelClassCenter">%v.25</td><td class="labelClassCenter">1,0%02d.5</td></tr>`, (i+1)*15, 20+i, i)
+	}
+	b.WriteString(`<tr><td colspan="4">Last Updated</td></tr></table></body></html>`)
+	return b.String()
+}
+
+func tableServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&fetches, 1)
+		// the third fetch has nothing new
+		if n > 2 {
+			n = 2
+		}
+		_, _ = w.Write([]byte(sppPage(int(n) + 1)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTMLTableWorker(t *testing.T) {
+	chicago, err := time.LoadLocation(ErcotZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := tableServer(t)
+	wkr, err := NewWorker("spp", &definitions.WorkerConfig{
+		Type:     "html_table",
+		Url:      srv.URL,
+		Table:    "table.tableStyle",
+		Rows:     "new",
+		Key:      "interval",
+		TimeZone: ErcotZone,
+		Columns: []*definitions.TableColumn{
+			{Header: "interval ending", Field: "interval", Type: "time", Format: "1504"},
+			{Header: "HB_HOUSTON", Field: "houston", Type: "float"},
+			{Position: 4, Field: "west", Type: "float"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// everything on the first run, then only what's new
+	for _, expected := range []int{2, 1} {
+		msg, err := wkr.Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Index != "spp" || len(msg.Records) != expected {
+			t.Fatalf("expected %v records, got %v", expected, len(msg.Records))
+		}
+	}
+	if _, err := wkr.Collect(context.Background()); !errors.Is(err, definitions.ErrNothingNew) {
+		t.Errorf("expected nothing new, got %v", err)
+	}
+
+	// the last row of the third page
+	wkr, _ = NewWorker("spp", &definitions.WorkerConfig{
+		Type: "html_table", Url: srv.URL, Table: "table.tableStyle", TimeZone: ErcotZone,
+		Columns: []*definitions.TableColumn{
+			{Position: 2, Field: "interval", Type: "time", Format: "1504"},
+			{Position: 3, Field: "houston", Type: "float"},
+			{Position: 4, Field: "west", Type: "float"},
+		},
+	})
+	msg, err := wkr.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := msg.Records[0]
+	if len(msg.Records) != 1 || last["houston"] != 22.25 || last["west"] != 1002.5 {
+		t.Errorf("expected the last row, got %v", msg.Records)
+	}
+	if at, ok := last["interval"].(time.Time); !ok || !at.Equal(time.Date(0, 1, 1, 0, 45, 0, 0, chicago)) {
+		t.Errorf("expected the interval as a time, got %v", last["interval"])
+	}
+}
+
+func TestHTMLTableWorkerHeaders(t *testing.T) {
+	srv := tableServer(t)
+	wkr, err := NewWorker("spp", &definitions.WorkerConfig{Type: "html_table", Url: srv.URL, Table: ".tableStyle", Rows: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := wkr.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the footer is too short to be a row
+	if len(msg.Records) != 2 || msg.Records[1]["HB_HOUSTON"] != "21.25" || msg.Records[0]["Oper Day"] != "12/14/2022" {
+		t.Errorf("expected the rows keyed by their headers, got %v", msg.Records)
+	}
+
+	wkr, _ = NewWorker("spp", &definitions.WorkerConfig{
+		Type: "html_table", Url: srv.URL, Table: ".tableStyle", HeaderRow: -1,
+		Columns: []*definitions.TableColumn{{Header: "HB_HOUSTON", Field: "houston"}},
+	})
+	if _, err := wkr.Collect(context.Background()); err == nil {
+		t.Error("expected a header column without a header row to fail")
+	}
+}
+
+func TestNewHTMLTableWorker(t *testing.T) {
+	bad := []*definitions.WorkerConfig{
+		{Type: "html_table"},
+		{Type: "html_table", Url: "http://example.com", Table: "table[["},
+		{Type: "html_table", Url: "http://example.com", Rows: "new"},
+		{Type: "html_table", Url: "http://example.com", Rows: "some"},
+		{Type: "html_table", Url: "http://example.com", Columns: []*definitions.TableColumn{{Field: "price"}}},
+		{Type: "html_table", Url: "http://example.com", Columns: []*definitions.TableColumn{{Position: 1, Field: "at", Type: "time"}}},
+		{Type: "html_table", Url: "http://example.com", Rows: "new", Key: "at", Columns: []*definitions.TableColumn{{Position: 1, Field: "price"}}},
+	}
+	for _, cfg := range bad {
+		if _, err := NewWorker("test", cfg); err == nil {
+			t.Errorf("expected %+v to fail", cfg)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// HTTPJSONWorker polls a json api and makes a record of whatever its Records path finds,
+// an array is a record per element
+type HTTPJSONWorker struct {
+	Index   string
+	Url     string
+	Method  string
+	Headers map[string]string
+	Query   map[string]string
+	Body    string
+	Records string
+	Rename  map[string]string
+	Client  *http.Client
+}
+
+func NewHTTPJSONWorker(index string, cfg *definitions.WorkerConfig) (*HTTPJSONWorker, error) {
+	if cfg.Url == "" {
+		return nil, errors.New("an http_json worker needs a url")
+	}
+	u, err := url.Parse(cfg.Url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("can't poll %v, expected an http or https url", cfg.Url)
+	}
+	method := strings.ToUpper(cfg.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	return &HTTPJSONWorker{
+		Index:   index,
+		Url:     cfg.Url,
+		Method:  method,
+		Headers: cfg.Headers,
+		Query:   cfg.Query,
+		Body:    cfg.Body,
+		Records: cfg.Records,
+		Rename:  cfg.Rename,
+		Client:  http.DefaultClient,
+	}, nil
+}
+
+func (w *HTTPJSONWorker) Collect(ctx context.Context) (definitions.ZincRecordV2, error) {
+	data, err := w.fetch(ctx)
+	if err != nil {
+		return definitions.ZincRecordV2{}, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return definitions.ZincRecordV2{}, fmt.Errorf("%v didn't return json: %w", w.Url, err)
+	}
+	found, ok := selectPath(doc, w.Records)
+	if !ok {
+		return definitions.ZincRecordV2{}, fmt.Errorf("nothing at %q in the response from %v", w.Records, w.Url)
+	}
+	records := asRecords(found)
+	if len(records) == 0 {
+		return definitions.ZincRecordV2{}, fmt.Errorf("no records at %q in the response from %v", w.Records, w.Url)
+	}
+	for _, r := range records {
+		rename(r, w.Rename)
+	}
+	return definitions.ZincRecordV2{
+		Index:   w.Index,
+		Records: records,
+	}, nil
+}
+
+func (w *HTTPJSONWorker) fetch(ctx context.Context) ([]byte, error) {
+	u, err := url.Parse(w.Url)
+	if err != nil {
+		return nil, err
+	}
+	if len(w.Query) > 0 {
+		q := u.Query()
+		for k, v := range w.Query {
+			q.Set(k, os.ExpandEnv(v))
+		}
+		u.RawQuery = q.Encode()
+	}
+	var body io.Reader
+	if w.Body != "" {
+		body = strings.NewReader(os.ExpandEnv(w.Body))
+	}
+	req, err := http.NewRequestWithContext(ctx, w.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	// errors name the url given, not the one with the query, which may carry a key
+	res, err := w.Client.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%v %v: %w", w.Method, w.Url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("%v returned %v", w.Url, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// asRecords makes records of what a path found, anything that isn't an object goes in a
+// record as its value
+func asRecords(found interface{}) []map[string]interface{} {
+	var records []map[string]interface{}
+	add := func(v interface{}) {
+		if m, ok := v.(map[string]interface{}); ok {
+			records = append(records, m)
+			return
+		}
+		records = append(records, map[string]interface{}{"value": v})
+	}
+	if list, ok := found.([]interface{}); ok {
+		for _, v := range list {
+			add(v)
+		}
+		return records
+	}
+	add(found)
+	return records
+}
+
+// rename moves fields to new names, a nested path is copied up to the top of the record
+func rename(record map[string]interface{}, renames map[string]string) {
+	found := make(map[string]interface{}, len(renames))
+	for from, to := range renames {
+		if v, ok := selectPath(record, from); ok {
+			found[to] = v
+		}
+	}
+	for from := range renames {
+		if parts := splitPath(from); len(parts) == 1 {
+			delete(record, parts[0])
+		}
+	}
+	for to, v := range found {
+		record[to] = v
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+func Test_selectPath(t *testing.T) {
+	var doc interface{}
+	body := `{"data": {"items": [{"id": 1, "loc": {"lat": 29.7}}, {"id": 2, "loc": {"lat": 30.2}}]}, "a.b": "dotted"}`
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		expected interface{}
+		ok       bool
+	}{
+		{"data.items.1.id", 2.0, true},
+		{"data.items.#.loc.lat", []interface{}{29.7, 30.2}, true},
+		{`a\.b`, "dotted", true},
+		{"data.items.2", nil, false},
+		{"data.nope", nil, false},
+		{"data.items.id", nil, false},
+	}
+	for _, tc := range tests {
+		got, ok := selectPath(doc, tc.path)
+		if ok != tc.ok || !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v: expected %v %v, got %v %v", tc.path, tc.expected, tc.ok, got, ok)
+		}
+	}
+	if got, ok := selectPath(doc, ""); !ok || !reflect.DeepEqual(got, doc) {
+		t.Error("expected an empty path to select the whole document")
+	}
+}
+
+func TestHTTPJSONWorker(t *testing.T) {
+	t.Setenv("RECORDS_TEST_KEY", "s3cret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "s3cret" || r.URL.Query().Get("zone") != "houston" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"results": {"stations": [{"name": "KHOU", "obs": {"temp_f": 71}}, {"name": "KIAH", "obs": {"temp_f": 69}}]}}`))
+	}))
+	defer srv.Close()
+
+	cfg := &definitions.WorkerConfig{
+		Type:    "http_json",
+		Url:     srv.URL,
+		Headers: map[string]string{"X-Api-Key": "$RECORDS_TEST_KEY"},
+		Query:   map[string]string{"zone": "houston"},
+		Records: "results.stations",
+		Rename:  map[string]string{"name": "station", "obs.temp_f": "temperature"},
+	}
+	wkr, err := NewWorker("stations", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := wkr.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Index != "stations" || len(msg.Records) != 2 {
+		t.Fatalf("expected 2 records for stations, got %v for %v", len(msg.Records), msg.Index)
+	}
+	first := msg.Records[0]
+	if first["station"] != "KHOU" || first["temperature"] != 71.0 || first["name"] != nil {
+		t.Errorf("expected the fields renamed, got %v", first)
+	}
+
+	cfg.Headers = nil
+	wkr, _ = NewWorker("stations", cfg)
+	if _, err := wkr.Collect(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the status in the error, got %v", err)
+	}
+	cfg.Headers = map[string]string{"X-Api-Key": "$RECORDS_TEST_KEY"}
+	cfg.Records = "results.missing"
+	wkr, _ = NewWorker("stations", cfg)
+	if _, err := wkr.Collect(context.Background()); err == nil {
+		t.Error("expected a path that finds nothing to fail")
+	}
+
+	// a request that never gets an answer doesn't give the key away
+	srv.Close()
+	cfg.Query = map[string]string{"key": "$RECORDS_TEST_KEY"}
+	wkr, _ = NewWorker("stations", cfg)
+	if _, err := wkr.Collect(context.Background()); err == nil || strings.Contains(err.Error(), "s3cret") || !strings.Contains(err.Error(), "GET "+srv.URL) {
+		t.Errorf("expected the failure without the query, got %v", err)
+	}
+}
+
+func TestNewWorker(t *testing.T) {
+	bad := []*definitions.WorkerConfig{
+		nil,
+		{Type: "carrier_pigeon"},
+		{Type: "http_json"},
+		{Type: "http_json", Url: "ftp://example.com/data.json"},
+	}
+	for _, cfg := range bad {
+		if _, err := NewWorker("test", cfg); err == nil {
+			t.Errorf("expected %+v to fail", cfg)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+)
+
+// selectPath walks a gjson style path through decoded json. keys and array indexes are
+// separated by dots, a `#` runs the rest of the path over every element of an array and
+// `\.` is a dot inside a key. an empty path is the whole document.
+func selectPath(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return doc, true
+	}
+	return walkPath(doc, splitPath(path))
+}
+
+func walkPath(v interface{}, parts []string) (interface{}, bool) {
+	if len(parts) == 0 {
+		return v, true
+	}
+	part, rest := parts[0], parts[1:]
+	switch node := v.(type) {
+	case map[string]interface{}:
+		child, ok := node[part]
+		if !ok {
+			return nil, false
+		}
+		return walkPath(child, rest)
+	case []interface{}:
+		if part == "#" {
+			out := []interface{}{}
+			for _, elem := range node {
+				if found, ok := walkPath(elem, rest); ok {
+					out = append(out, found)
+				}
+			}
+			return out, true
+		}
+		i, err := strconv.Atoi(part)
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, false
+		}
+		return walkPath(node[i], rest)
+	}
+	return nil, false
+}
+
+func splitPath(path string) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path) && path[i+1] == '.':
+			part.WriteByte('.')
+			i++
+		case path[i] == '.':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(path[i])
+		}
+	}
+	return append(parts, part.String())
+}
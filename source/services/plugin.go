@@ -0,0 +1,442 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// a plugin is a long running process collecting for one or more services. records writes
+// a request per line to its stdin and reads a response per line from its stdout:
+//
+//	{"id": 1, "method": "collect", "service": "grid", "params": {"zone": "houston"}}
+//	{"id": 1, "index": "grid", "records": [{"load": 61.5}], "error": "", "warning": ""}
+//
+// responses may come back in any order, the id ties them to their request. stderr is kept
+// for the plugin's health. a plugin that exits is started again, after a backoff that grows
+// for as long as it keeps crashing.
+
+const (
+	PluginRunning    = "running"
+	PluginRestarting = "restarting"
+	PluginStopped    = "stopped"
+)
+
+const (
+	pluginBackoff    = time.Second
+	pluginMaxBackoff = time.Minute
+	// a plugin that stayed up this long goes back to the shortest backoff
+	pluginStable      = time.Minute
+	pluginStderrLines = 20
+	pluginMaxLine     = 16 << 20
+)
+
+type pluginRequest struct {
+	Id      uint64                 `json:"id"`
+	Method  string                 `json:"method"`
+	Service string                 `json:"service"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+type pluginResponse struct {
+	Id      uint64                   `json:"id"`
+	Index   string                   `json:"index"`
+	Records []map[string]interface{} `json:"records"`
+	Error   string                   `json:"error"`
+	Warning string                   `json:"warning"`
+}
+
+// PluginStatus is how a plugin is doing, for the runtime api
+type PluginStatus struct {
+	Command   string    `json:"command"`
+	Args      []string  `json:"args"`
+	Services  []string  `json:"services"`
+	State     string    `json:"state"`
+	Pid       int       `json:"pid"`
+	Started   time.Time `json:"started"`
+	Restarts  int       `json:"restarts"`
+	Requests  int       `json:"requests"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error"`
+	Stderr    []string  `json:"stderr"`
+}
+
+// Plugin supervises a plugin process, services configured with the same command, args,
+// env and dir share one
+type Plugin struct {
+	Command string
+	Args    []string
+	Env     map[string]string
+	Dir     string
+
+	mtx      sync.Mutex
+	status   PluginStatus
+	services map[string]bool
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	pending  map[uint64]chan pluginResponse
+	seq      uint64
+	backoff  time.Duration
+	stopped  bool
+	// writes to stdin, so lines don't interleave
+	wmtx sync.Mutex
+}
+
+var plugins = struct {
+	sync.Mutex
+	m map[string]*Plugin
+}{m: make(map[string]*Plugin)}
+
+// pluginFor finds or makes the plugin for cfg
+func pluginFor(cfg *definitions.WorkerConfig) *Plugin {
+	key, _ := json.Marshal([]interface{}{cfg.Command, cfg.Args, cfg.Env, cfg.Dir})
+	plugins.Lock()
+	defer plugins.Unlock()
+	if p, ok := plugins.m[string(key)]; ok {
+		p.mtx.Lock()
+		stopped := p.stopped
+		p.mtx.Unlock()
+		// a stopped plugin stays that way, a service started after gets a new one
+		if !stopped {
+			return p
+		}
+	}
+	p := &Plugin{
+		Command:  cfg.Command,
+		Args:     cfg.Args,
+		Env:      cfg.Env,
+		Dir:      cfg.Dir,
+		services: make(map[string]bool),
+		pending:  make(map[uint64]chan pluginResponse),
+	}
+	plugins.m[string(key)] = p
+	return p
+}
+
+// Plugins reports on every plugin started so far
+func Plugins() []PluginStatus {
+	plugins.Lock()
+	defer plugins.Unlock()
+	out := make([]PluginStatus, 0, len(plugins.m))
+	for _, p := range plugins.m {
+		out = append(out, p.Status())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Command < out[j].Command
+	})
+	return out
+}
+
+// StopPlugins ends every plugin for good
+func StopPlugins() {
+	plugins.Lock()
+	defer plugins.Unlock()
+	for _, p := range plugins.m {
+		p.Stop()
+	}
+}
+
+func (p *Plugin) Status() PluginStatus {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	status := p.status
+	status.Command = p.Command
+	status.Args = p.Args
+	status.Stderr = append([]string(nil), p.status.Stderr...)
+	for s := range p.services {
+		status.Services = append(status.Services, s)
+	}
+	sort.Strings(status.Services)
+	return status
+}
+
+// Stop ends the plugin without starting it again
+func (p *Plugin) Stop() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.stopped = true
+	p.status.State = PluginStopped
+	if p.cmd != nil {
+		p.stdin.Close()
+		_ = p.cmd.Process.Kill()
+	}
+}
+
+// start launches the process, p.mtx is held
+func (p *Plugin) start() error {
+	cmd := exec.Command(p.Command, p.Args...)
+	cmd.Dir = p.Dir
+	cmd.Env = os.Environ()
+	for k, v := range p.Env {
+		cmd.Env = append(cmd.Env, k+"="+os.ExpandEnv(v))
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p.cmd = cmd
+	p.stdin = stdin
+	p.status.State = PluginRunning
+	p.status.Pid = cmd.Process.Pid
+	p.status.Started = Clock.Now()
+
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		p.readStderr(stderr)
+	}()
+	go func() {
+		p.read(stdout)
+		// every read from the pipes has to be done before waiting
+		<-stderrDone
+		p.exited(cmd.Wait())
+	}()
+	return nil
+}
+
+// read hands each response to the request waiting on it
+func (p *Plugin) read(stdout io.Reader) {
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 64*1024), pluginMaxLine)
+	for sc.Scan() {
+		var res pluginResponse
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+			p.mtx.Lock()
+			p.status.LastError = fmt.Sprintf("bad response: %v", err)
+			p.mtx.Unlock()
+			continue
+		}
+		p.mtx.Lock()
+		ch, ok := p.pending[res.Id]
+		delete(p.pending, res.Id)
+		p.mtx.Unlock()
+		if ok {
+			ch <- res
+		}
+	}
+	if err := sc.Err(); err != nil {
+		// a plugin we can't read from any more is no use, start over
+		p.mtx.Lock()
+		p.status.LastError = err.Error()
+		if p.cmd != nil {
+			_ = p.cmd.Process.Kill()
+		}
+		p.mtx.Unlock()
+	}
+}
+
+func (p *Plugin) readStderr(stderr io.Reader) {
+	sc := bufio.NewScanner(stderr)
+	for sc.Scan() {
+		p.mtx.Lock()
+		p.status.Stderr = append(p.status.Stderr, sc.Text())
+		if len(p.status.Stderr) > pluginStderrLines {
+			p.status.Stderr = p.status.Stderr[len(p.status.Stderr)-pluginStderrLines:]
+		}
+		p.mtx.Unlock()
+	}
+}
+
+// exited fails whatever was waiting on the process and starts it again, unless stopped
+func (p *Plugin) exited(err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for id, ch := range p.pending {
+		close(ch)
+		delete(p.pending, id)
+	}
+	p.cmd = nil
+	p.stdin = nil
+	p.status.Pid = 0
+	if p.stopped {
+		return
+	}
+	p.status.LastError = "plugin exited"
+	if err != nil {
+		p.status.LastError += ": " + err.Error()
+	}
+	if Clock.Since(p.status.Started) >= pluginStable {
+		p.backoff = 0
+	}
+	p.restart()
+}
+
+// restart starts the process again after the backoff, p.mtx is held
+func (p *Plugin) restart() {
+	switch {
+	case p.backoff == 0:
+		p.backoff = pluginBackoff
+	case p.backoff < pluginMaxBackoff:
+		p.backoff *= 2
+		if p.backoff > pluginMaxBackoff {
+			p.backoff = pluginMaxBackoff
+		}
+	}
+	p.status.State = PluginRestarting
+	wait := Clock.After(p.backoff)
+	go func() {
+		<-wait
+		p.mtx.Lock()
+		defer p.mtx.Unlock()
+		if p.stopped {
+			return
+		}
+		p.status.Restarts++
+		if err := p.start(); err != nil {
+			p.status.LastError = err.Error()
+			p.restart()
+		}
+	}()
+}
+
+// collect asks the plugin for service's records
+func (p *Plugin) collect(ctx context.Context, service string, params map[string]interface{}) (pluginResponse, error) {
+	res, err := p.call(ctx, pluginRequest{Method: "collect", Service: service, Params: params})
+	if err == nil && res.Error != "" {
+		err = errors.New(res.Error)
+	}
+	if err != nil {
+		p.mtx.Lock()
+		p.status.Failures++
+		p.mtx.Unlock()
+	}
+	return res, err
+}
+
+func (p *Plugin) call(ctx context.Context, req pluginRequest) (pluginResponse, error) {
+	p.mtx.Lock()
+	// the first call starts the plugin
+	if p.status.State == "" {
+		if err := p.start(); err != nil {
+			p.status.LastError = err.Error()
+			p.restart()
+		}
+	}
+	if p.stdin == nil {
+		defer p.mtx.Unlock()
+		return pluginResponse{}, fmt.Errorf("%v is %v: %v", filepath.Base(p.Command), p.status.State, p.status.LastError)
+	}
+	p.seq++
+	req.Id = p.seq
+	ch := make(chan pluginResponse, 1)
+	p.pending[req.Id] = ch
+	p.status.Requests++
+	stdin, cmd := p.stdin, p.cmd
+	p.mtx.Unlock()
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		p.forget(req.Id)
+		return pluginResponse{}, err
+	}
+	written := make(chan error, 1)
+	go func() {
+		p.wmtx.Lock()
+		defer p.wmtx.Unlock()
+		_, err := stdin.Write(append(line, '\n'))
+		written <- err
+	}()
+	select {
+	case err = <-written:
+	case <-ctx.Done():
+		// a plugin that stopped reading its stdin is wedged, killing it fails the write
+		// and exited starts it over
+		p.forget(req.Id)
+		p.mtx.Lock()
+		p.status.LastError = "timed out writing a request"
+		p.mtx.Unlock()
+		_ = cmd.Process.Kill()
+		return pluginResponse{}, ctx.Err()
+	}
+	if err != nil {
+		p.forget(req.Id)
+		return pluginResponse{}, fmt.Errorf("can't write to %v: %w", filepath.Base(p.Command), err)
+	}
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			return pluginResponse{}, fmt.Errorf("%v exited before answering", filepath.Base(p.Command))
+		}
+		return res, nil
+	case <-ctx.Done():
+		p.forget(req.Id)
+		return pluginResponse{}, ctx.Err()
+	}
+}
+
+func (p *Plugin) forget(id uint64) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	delete(p.pending, id)
+}
+
+// PluginWorker collects a service's records from a plugin
+type PluginWorker struct {
+	Index   string
+	Service string
+	Params  map[string]interface{}
+	Plugin  *Plugin
+}
+
+func NewPluginWorker(service, index string, cfg *definitions.WorkerConfig) (*PluginWorker, error) {
+	if cfg.Command == "" {
+		return nil, errors.New("a plugin worker needs a command")
+	}
+	if _, err := exec.LookPath(cfg.Command); err != nil {
+		return nil, err
+	}
+	p := pluginFor(cfg)
+	p.mtx.Lock()
+	p.services[service] = true
+	p.mtx.Unlock()
+	return &PluginWorker{
+		Index:   index,
+		Service: service,
+		Params:  cfg.Params,
+		Plugin:  p,
+	}, nil
+}
+
+func (w *PluginWorker) Collect(ctx context.Context) (definitions.ZincRecordV2, error) {
+	res, err := w.Plugin.collect(ctx, w.Service, w.Params)
+	if err != nil {
+		return definitions.ZincRecordV2{}, err
+	}
+	if len(res.Records) == 0 {
+		return definitions.ZincRecordV2{}, definitions.ErrNothingNew
+	}
+	msg := definitions.ZincRecordV2{
+		Index:   w.Index,
+		Records: res.Records,
+	}
+	if res.Index != "" {
+		msg.Index = res.Index
+	}
+	if res.Warning != "" {
+		return msg, &definitions.Warning{Err: errors.New(strings.TrimSpace(res.Warning))}
+	}
+	return msg, nil
+}
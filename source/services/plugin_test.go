@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+	"github.com/rexlx/records/source/schedule"
+)
+
+// the test binary doubles as a plugin when RECORDS_FAKE_PLUGIN is set
+func TestMain(m *testing.M) {
+	if os.Getenv("RECORDS_FAKE_PLUGIN") != "" {
+		fakePlugin()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakePlugin counts its calls, so a test can tell whether it is still the same process
+func fakePlugin() {
+	calls := 0
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		var req pluginRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		calls++
+		res := pluginResponse{Id: req.Id}
+		switch req.Service {
+		case "crash":
+			fmt.Fprintln(os.Stderr, "going down")
+			os.Exit(3)
+		case "fail":
+			res.Error = "no data"
+		default:
+			res.Records = []map[string]interface{}{{"calls": calls, "pid": os.Getpid(), "zone": req.Params["zone"]}}
+		}
+		out, _ := json.Marshal(res)
+		fmt.Println(string(out))
+	}
+}
+
+func pluginWorker(t *testing.T, service string) *PluginWorker {
+	t.Helper()
+	wkr, err := NewWorker(service, &definitions.WorkerConfig{
+		Type:    "plugin",
+		Command: os.Args[0],
+		Args:    []string{t.Name()},
+		Env:     map[string]string{"RECORDS_FAKE_PLUGIN": "1"},
+		Params:  map[string]interface{}{"zone": "houston"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wkr.(*PluginWorker)
+}
+
+func TestPluginWorker(t *testing.T) {
+	clock := schedule.NewFakeClock(time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC))
+	previous := Clock
+	Clock = clock
+	t.Cleanup(func() { Clock = previous })
+
+	grid := pluginWorker(t, "grid")
+	crash := pluginWorker(t, "crash")
+	fail := pluginWorker(t, "fail")
+	if grid.Plugin != crash.Plugin || grid.Plugin != fail.Plugin {
+		t.Fatal("expected the services to share a plugin")
+	}
+	t.Cleanup(grid.Plugin.Stop)
+
+	var pid interface{}
+	for i := 1; i <= 2; i++ {
+		msg, err := grid.Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := msg.Records[0]
+		if msg.Index != "grid" || r["calls"] != float64(i) || r["zone"] != "houston" {
+			t.Fatalf("expected call %v for grid, got %v", i, msg)
+		}
+		if pid != nil && r["pid"] != pid {
+			t.Error("expected the plugin to keep running between calls")
+		}
+		pid = r["pid"]
+	}
+	if _, err := fail.Collect(context.Background()); err == nil || err.Error() != "no data" {
+		t.Errorf("expected the plugin's error, got %v", err)
+	}
+
+	if _, err := crash.Collect(context.Background()); err == nil || !strings.Contains(err.Error(), "exited before answering") {
+		t.Fatalf("expected the crash to fail the call, got %v", err)
+	}
+	status := grid.Plugin.Status()
+	if status.State != PluginRestarting || !strings.Contains(status.LastError, "exit status 3") {
+		t.Errorf("expected the plugin to be restarting after exiting with 3, got %+v", status)
+	}
+	if len(status.Stderr) != 1 || status.Stderr[0] != "going down" {
+		t.Errorf("expected the plugin's stderr, got %v", status.Stderr)
+	}
+	if status.Requests != 4 || status.Failures != 2 {
+		t.Errorf("expected 4 requests and 2 failures, got %v and %v", status.Requests, status.Failures)
+	}
+	if _, err := grid.Collect(context.Background()); err == nil || !strings.Contains(err.Error(), "is restarting") {
+		t.Errorf("expected calls to fail while restarting, got %v", err)
+	}
+
+	clock.BlockUntil(1)
+	clock.Advance(pluginBackoff)
+	deadline := time.Now().Add(5 * time.Second)
+	for grid.Plugin.Status().State != PluginRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("the plugin was never restarted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	msg, err := grid.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := msg.Records[0]; r["calls"] != 1.0 || r["pid"] == pid {
+		t.Errorf("expected a new process, got %v", r)
+	}
+	if status := grid.Plugin.Status(); status.Restarts != 1 || len(status.Services) != 3 {
+		t.Errorf("expected 1 restart serving 3 services, got %+v", status)
+	}
+
+	grid.Plugin.Stop()
+	if _, err := grid.Collect(context.Background()); err == nil {
+		t.Error("expected a stopped plugin to fail")
+	}
+}
+
+func TestPluginWorkerTimeout(t *testing.T) {
+	wkr, err := NewWorker("slow", &definitions.WorkerConfig{Type: "plugin", Command: "sleep", Args: []string{"10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(wkr.(*PluginWorker).Plugin.Stop)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := wkr.Collect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a plugin that never answers to time out, got %v", err)
+	}
+
+	// a request too big for the pipe blocks on a plugin that doesn't read, it is killed
+	wkr, err = NewWorker("stuck", &definitions.WorkerConfig{
+		Type:    "plugin",
+		Command: "sleep",
+		Args:    []string{"11"},
+		Params:  map[string]interface{}{"blob": strings.Repeat("x", 1<<20)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck := wkr.(*PluginWorker).Plugin
+	t.Cleanup(stuck.Stop)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := wkr.Collect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the write to time out, got %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stuck.Status().State != PluginRestarting {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the plugin to be killed and restarting, got %+v", stuck.Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := NewWorker("missing", &definitions.WorkerConfig{Type: "plugin", Command: "no-such-plugin"}); err == nil {
+		t.Error("expected a missing command to fail")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package services
+
+import "os/exec"
+
+func ownGroup(cmd *exec.Cmd) {}
+
+// killGroup can only kill cmd itself here
+func killGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package services
+
+import "syscall"
+
+func diskUsage(path string) (total, free, available uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, 0, err
+	}
+	size := uint64(st.Bsize)
+	return uint64(st.Blocks) * size, uint64(st.Bfree) * size, uint64(st.Bavail) * size, nil
+}
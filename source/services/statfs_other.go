@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+
+package services
+
+import (
+	"errors"
+	"runtime"
+)
+
+func diskUsage(path string) (total, free, available uint64, err error) {
+	return 0, 0, 0, errors.New("mount usage isn't supported on " + runtime.GOOS)
+}
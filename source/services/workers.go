@@ -1,11 +1,12 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,38 +16,29 @@ import (
 	"golang.org/x/net/html"
 )
 
-func GetRealTimeSysCon(c chan definitions.ZincRecordV2) {
-	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodGet, ErcotRTSC, nil)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		log.Println(err)
-		return
-	}
+// RtscWorker collects ERCOT's real time system conditions
+type RtscWorker struct{}
 
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	doc, err := html.Parse(strings.NewReader(string(data)))
+func (RtscWorker) Collect(ctx context.Context) (definitions.ZincRecordV2, error) {
+	return GetRealTimeSysCon(ctx)
+}
+
+func (RtscWorker) Hosts() []string {
+	return hostsOf(ErcotRTSC)
+}
+
+func GetRealTimeSysCon(ctx context.Context) (definitions.ZincRecordV2, error) {
+	doc, err := getHTML(ctx, ErcotRTSC)
 	if err != nil {
-		log.Println(err)
-		return
+		return definitions.ZincRecordV2{}, err
 	}
 	result := PowerParser(doc)
+	if len(result) <= DC_S {
+		return definitions.ZincRecordV2{}, fmt.Errorf("expected %v values at %v, found %v", DC_S+1, ErcotRTSC, len(result))
+	}
 	rtsc_res := definitions.SysConResponse{
 		Error:                  false,
-		Time:                   time.Now(),
+		Time:                   Clock.Now(),
 		Freq:                   result[CurrentFrequency].Value,
 		InstantaneousTimeError: result[InstantaneousTimeError].Value,
 		BAALExceedances:        result[BAALExceedances].Value,
@@ -62,127 +54,153 @@ func GetRealTimeSysCon(c chan definitions.ZincRecordV2) {
 		DC_R:                   result[DC_R].Value,
 		DC_S:                   result[DC_S].Value,
 	}
-	var envelope []map[string]interface{}
-	var tmp map[string]interface{}
-	out, err := json.Marshal(rtsc_res)
+	tmp, err := toMap(rtsc_res)
 	if err != nil {
-		log.Println(err)
-		return
+		return definitions.ZincRecordV2{}, err
 	}
-	json.Unmarshal(out, &tmp)
-	envelope = append(envelope, tmp)
-	c <- definitions.ZincRecordV2{
+	return definitions.ZincRecordV2{
 		Index:   "ercotRTSC",
-		Records: envelope,
-	}
-
+		Records: []map[string]interface{}{tmp},
+	}, nil
 }
 
-func GetSPP(c chan definitions.ZincRecordV2) {
+func GetSPP(ctx context.Context) (definitions.ZincRecordV2, error) {
 	var vals []*definitions.Spp
-	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodGet, ErcotSPP, nil)
+	doc, err := getHTML(ctx, ErcotSPP)
 	if err != nil {
-		log.Println(err)
-		return
+		return definitions.ZincRecordV2{}, err
 	}
 
-	res, err := client.Do(req)
+	for _, item := range SppParser(doc) {
+		if df := sppRow(item); df != nil {
+			vals = append(vals, df)
+		}
+	}
+	if len(vals) < 1 {
+		return definitions.ZincRecordV2{}, fmt.Errorf("no prices found at %v", ErcotSPP)
+	}
+	tmp, err := toMap(vals[len(vals)-1])
 	if err != nil {
-		log.Println(err)
-		return
+		return definitions.ZincRecordV2{}, err
 	}
+	return definitions.ZincRecordV2{
+		Index:   "ErcotSPP",
+		Records: []map[string]interface{}{tmp},
+	}, nil
+}
 
-	defer res.Body.Close()
+// SppWorker collects settlement point prices. besides the latest interval it can read
+// ERCOT's daily reports for a backfill.
+type SppWorker struct{}
 
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Println(err)
-		return
-	}
+func (SppWorker) Collect(ctx context.Context) (definitions.ZincRecordV2, error) {
+	return GetSPP(ctx)
+}
+
+// Hosts covers the daily reports too, they are on the same site
+func (SppWorker) Hosts() []string {
+	return hostsOf(ErcotSPP)
+}
 
-	doc, err := html.Parse(strings.NewReader(string(data)))
+// CollectRange returns a record for every interval ending in [from, to)
+func (SppWorker) CollectRange(ctx context.Context, from, to time.Time) ([]definitions.ZincRecordV2, error) {
+	loc, err := time.LoadLocation(ErcotZone)
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
-
-	values := SppParser(doc)
-
-	for _, item := range values {
-		df := &definitions.Spp{
-			Date:      fmt.Sprintf("%v %v", item[0], item[1]),
-			HbBusAvg:  toFloat32(item[2]),
-			HbHouston: toFloat32(item[3]),
-			HbHubAvg:  toFloat32(item[4]),
-			HbNorth:   toFloat32(item[5]),
-			HbPan:     toFloat32(item[6]),
-			HbSouth:   toFloat32(item[7]),
-			HbWest:    toFloat32(item[8]),
-			LzAen:     toFloat32(item[9]),
-			LzCps:     toFloat32(item[10]),
-			LzHouston: toFloat32(item[11]),
-			LzLcra:    toFloat32(item[12]),
-			LzNorth:   toFloat32(item[13]),
-			LzRaybn:   toFloat32(item[14]),
-			LzSouth:   toFloat32(item[15]),
-			LzWest:    toFloat32(item[16]),
+	var out []definitions.ZincRecordV2
+	first := from.In(loc)
+	// an interval ending at midnight is on the previous day's report
+	for day := time.Date(first.Year(), first.Month(), first.Day()-1, 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		doc, err := getHTML(ctx, fmt.Sprintf(ErcotHistoricalSPP, day.Format("20060102")))
+		if err != nil {
+			return out, err
 		}
-		vals = append(vals, df)
-	}
-	var envelope []map[string]interface{}
-	var tmp map[string]interface{}
-	if len(vals) < 1 {
-		c <- definitions.ZincRecordV2{
-			Index:   "EmptyData",
-			Records: envelope,
+		for _, item := range SppParser(doc) {
+			df := sppRow(item)
+			if df == nil {
+				continue
+			}
+			at, err := sppTime(item[0], item[1], loc)
+			if err != nil {
+				return out, err
+			}
+			if at.Before(from) || !at.Before(to) {
+				continue
+			}
+			tmp, err := toMap(df)
+			if err != nil {
+				return out, err
+			}
+			tmp[definitions.TimestampField] = at.Format(time.RFC3339)
+			out = append(out, definitions.ZincRecordV2{
+				Index:   "ErcotSPP",
+				Records: []map[string]interface{}{tmp},
+				Time:    at,
+			})
 		}
-		return
 	}
-	out, err := json.Marshal(vals[len(vals)-1])
-	if err != nil {
-		log.Println(err)
-		return
+	return out, nil
+}
+
+// sppRow reads a row of the prices table, nil if it is too short to be one
+func sppRow(item []string) *definitions.Spp {
+	if len(item) < 17 {
+		return nil
+	}
+	return &definitions.Spp{
+		Date:      fmt.Sprintf("%v %v", item[0], item[1]),
+		HbBusAvg:  toFloat32(item[2]),
+		HbHouston: toFloat32(item[3]),
+		HbHubAvg:  toFloat32(item[4]),
+		HbNorth:   toFloat32(item[5]),
+		HbPan:     toFloat32(item[6]),
+		HbSouth:   toFloat32(item[7]),
+		HbWest:    toFloat32(item[8]),
+		LzAen:     toFloat32(item[9]),
+		LzCps:     toFloat32(item[10]),
+		LzHouston: toFloat32(item[11]),
+		LzLcra:    toFloat32(item[12]),
+		LzNorth:   toFloat32(item[13]),
+		LzRaybn:   toFloat32(item[14]),
+		LzSouth:   toFloat32(item[15]),
+		LzWest:    toFloat32(item[16]),
 	}
-	json.Unmarshal(out, &tmp)
-	envelope = append(envelope, tmp)
+}
 
-	c <- definitions.ZincRecordV2{
-		Index:   "ErcotSPP",
-		Records: envelope,
+// sppTime reads an operating day like 12/14/2022 and an interval ending like 1015, where
+// 2400 is midnight at the end of the day
+func sppTime(day, ending string, loc *time.Location) (time.Time, error) {
+	d, err := time.ParseInLocation("01/02/2006", strings.TrimSpace(day), loc)
+	if err != nil {
+		return time.Time{}, err
 	}
+	hhmm, err := strconv.Atoi(strings.TrimSpace(ending))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad interval ending %q", ending)
+	}
+	return time.Date(d.Year(), d.Month(), d.Day(), hhmm/100, hhmm%100, 0, 0, loc), nil
 }
 
-func GetWeather(c chan definitions.ZincRecordV2) {
+func GetWeather(ctx context.Context) (definitions.ZincRecordV2, error) {
 	cities := []string{"houston", "galveston", "dallas", "austin", "odessa"}
 	var wg sync.WaitGroup
+	var mtx sync.Mutex
 	var vals []*definitions.WeatherResponse
+	var errs []string
 	for _, i := range cities {
-		var val definitions.WeatherResponse
 		wg.Add(1)
 		go func(i string) {
 			defer wg.Done()
-			client := &http.Client{}
-			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(WeatherUri, i), nil)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			res, err := client.Do(req)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			defer res.Body.Close()
-
-			x, err := io.ReadAll(res.Body)
-			if err != nil {
-				log.Println(err)
-				return
+			var val definitions.WeatherResponse
+			body, err := get(ctx, fmt.Sprintf(WeatherUri, i))
+			if err == nil {
+				err = json.Unmarshal(body, &val)
 			}
-			err = json.Unmarshal(x, &val)
+			mtx.Lock()
+			defer mtx.Unlock()
 			if err != nil {
-				log.Println(err)
+				errs = append(errs, fmt.Sprintf("%v: %v", i, err))
 				return
 			}
 			vals = append(vals, &val)
@@ -194,70 +212,136 @@ func GetWeather(c chan definitions.ZincRecordV2) {
 		if i.Location == nil {
 			continue
 		}
-		var tmp map[string]interface{}
-		out, err := json.Marshal(i)
+		tmp, err := toMap(i)
 		if err != nil {
-			log.Println(err)
-			return
+			return definitions.ZincRecordV2{}, err
 		}
-		json.Unmarshal(out, &tmp)
 		envelope = append(envelope, tmp)
 	}
+	// a few cities failing still leaves a useful record
+	if len(envelope) == 0 && len(errs) > 0 {
+		return definitions.ZincRecordV2{}, fmt.Errorf("no weather collected: %v", strings.Join(errs, "; "))
+	}
 
-	c <- definitions.ZincRecordV2{
+	return definitions.ZincRecordV2{
 		Index:   "verySpecialWeather",
 		Records: envelope,
-	}
+	}, nil
 }
 
-func CpuMon(c chan definitions.ZincRecordV2) {
-	stream := make(chan []*performance.CpuUsage)
+func CpuMon(ctx context.Context) (definitions.ZincRecordV2, error) {
+	stream := make(chan []*performance.CpuUsage, 1)
 	go performance.GetCpuValues(stream, 2)
-	msg := <-stream
+	var msg []*performance.CpuUsage
+	select {
+	case msg = <-stream:
+	case <-ctx.Done():
+		return definitions.ZincRecordV2{}, ctx.Err()
+	}
 	var envelope []map[string]interface{}
 	for _, i := range msg {
-		var tmp map[string]interface{}
-		out, err := json.Marshal(i)
+		tmp, err := toMap(i)
 		if err != nil {
-			log.Println(err)
-			return
+			return definitions.ZincRecordV2{}, err
 		}
-		json.Unmarshal(out, &tmp)
 		envelope = append(envelope, tmp)
 	}
-	c <- definitions.ZincRecordV2{
+	return definitions.ZincRecordV2{
 		Index:   "cpuMonRxlx",
 		Records: envelope,
+	}, nil
+}
+
+// PowerWorker combines prices, system conditions and weather into one record. configured
+// with depends_on it builds it from those services' records, otherwise it fetches all
+// three itself.
+type PowerWorker struct{}
+
+func (PowerWorker) Collect(ctx context.Context) (definitions.ZincRecordV2, error) {
+	return PowerMonitor(ctx)
+}
+
+// Derive sorts its inputs by index, so it doesn't matter what the services are called
+func (PowerWorker) Derive(ctx context.Context, inputs map[string]definitions.ZincRecordV2) (definitions.ZincRecordV2, error) {
+	var parts []definitions.ZincRecordV2
+	for _, in := range inputs {
+		parts = append(parts, in)
+	}
+	return power(parts...)
+}
+
+func PowerMonitor(ctx context.Context) (definitions.ZincRecordV2, error) {
+	var parts []definitions.ZincRecordV2
+	for _, get := range []func(context.Context) (definitions.ZincRecordV2, error){GetSPP, GetRealTimeSysCon, GetWeather} {
+		msg, err := get(ctx)
+		if err != nil {
+			return msg, err
+		}
+		parts = append(parts, msg)
 	}
+	return power(parts...)
 }
 
-func PowerMonitor(c chan definitions.ZincRecordV2) {
+func power(parts ...definitions.ZincRecordV2) (definitions.ZincRecordV2, error) {
 	var container struct {
 		Spp     []map[string]interface{} `json:"prices"`
 		Rtsc    []map[string]interface{} `json:"system"`
 		Weather []map[string]interface{} `json:"weather"`
 	}
-	newChan := make(chan definitions.ZincRecordV2)
-	var vals []map[string]interface{}
-	go GetSPP(newChan)
-	msg := <-newChan
-	container.Spp = append(container.Spp, msg.Records...)
-	go GetRealTimeSysCon(newChan)
-	msg = <-newChan
-	container.Rtsc = append(container.Rtsc, msg.Records...)
-	go GetWeather(newChan)
-	msg = <-newChan
-	container.Weather = append(container.Weather, msg.Records...)
-
-	var tmp map[string]interface{}
-	out, err := json.Marshal(container)
+	for _, msg := range parts {
+		switch msg.Index {
+		case "ErcotSPP":
+			container.Spp = append(container.Spp, msg.Records...)
+		case "ercotRTSC":
+			container.Rtsc = append(container.Rtsc, msg.Records...)
+		case "verySpecialWeather":
+			container.Weather = append(container.Weather, msg.Records...)
+		default:
+			return definitions.ZincRecordV2{}, fmt.Errorf("power monitor can't use %v records", msg.Index)
+		}
+	}
+	tmp, err := toMap(container)
 	if err != nil {
-		log.Println(err)
+		return definitions.ZincRecordV2{}, err
 	}
-	json.Unmarshal(out, &tmp)
-	vals = append(vals, tmp)
-	c <- definitions.ZincRecordV2{
+	return definitions.ZincRecordV2{
 		Index:   "PowerMonitor",
-		Records: vals,
+		Records: []map[string]interface{}{tmp},
+	}, nil
+}
+
+// get fetches uri, anything but a 200 is an error
+func get(ctx context.Context, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v returned %v", uri, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func getHTML(ctx context.Context, uri string) (*html.Node, error) {
+	data, err := get(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return html.Parse(strings.NewReader(string(data)))
+}
+
+// toMap round trips v through json so it can go in a record
+func toMap(v interface{}) (map[string]interface{}, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tmp map[string]interface{}
+	err = json.Unmarshal(out, &tmp)
+	return tmp, err
 }
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_sppTime(t *testing.T) {
+	loc, err := time.LoadLocation(ErcotZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		day, ending string
+		expected    time.Time
+	}{
+		{"12/14/2022", "1015", time.Date(2022, 12, 14, 10, 15, 0, 0, loc)},
+		{"12/14/2022", "0015", time.Date(2022, 12, 14, 0, 15, 0, 0, loc)},
+		{"12/14/2022", "2400", time.Date(2022, 12, 15, 0, 0, 0, 0, loc)},
+	}
+	for _, tc := range tests {
+		got, err := sppTime(tc.day, tc.ending, loc)
+		if err != nil {
+			t.Errorf("%v %v: %v", tc.day, tc.ending, err)
+			continue
+		}
+		if !got.Equal(tc.expected) {
+			t.Errorf("%v %v: expected %v, got %v", tc.day, tc.ending, tc.expected, got)
+		}
+	}
+	if _, err := sppTime("2022-12-14", "1015", loc); err == nil {
+		t.Error("expected a bad day to fail")
+	}
+}
@@ -0,0 +1,142 @@
+package sinks
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// Batcher collects records per index and hands them to the sink behind it as a single
+// record once a count, size or age threshold is crossed. a zero threshold is ignored.
+type Batcher struct {
+	sink     definitions.Sink
+	MaxCount int
+	MaxBytes int
+	MaxAge   time.Duration
+	logger   *log.Logger
+	mtx      sync.Mutex
+	batches  map[string]*batch
+	closed   bool
+}
+
+type batch struct {
+	records []map[string]interface{}
+	bytes   int
+	timer   *time.Timer
+}
+
+// NewBatcher wraps sink with a batcher using the given thresholds, batches sent because
+// of their age have nobody to return an error to so failures go to logger
+func NewBatcher(sink definitions.Sink, count, bytes int, age time.Duration, logger *log.Logger) *Batcher {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &Batcher{
+		sink:     sink,
+		MaxCount: count,
+		MaxBytes: bytes,
+		MaxAge:   age,
+		logger:   logger,
+		batches:  make(map[string]*batch),
+	}
+}
+
+// Write adds the record to its index's batch, sending the batch on if it is full
+func (b *Batcher) Write(record definitions.ZincRecordV2) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	// historical records would lose their time merged into a batch
+	if b.closed || record.Historical() {
+		return b.sink.Write(record)
+	}
+	bt, ok := b.batches[record.Index]
+	if !ok {
+		bt = &batch{}
+		b.batches[record.Index] = bt
+		if b.MaxAge > 0 {
+			index := record.Index
+			bt.timer = time.AfterFunc(b.MaxAge, func() {
+				b.mtx.Lock()
+				defer b.mtx.Unlock()
+				// the batch may have been sent and replaced since the timer was set
+				if b.batches[index] != bt {
+					return
+				}
+				if err := b.send(index); err != nil {
+					b.logger.Println("batch for", index, err)
+				}
+			})
+		}
+	}
+	for _, r := range record.Records {
+		out, err := json.Marshal(r)
+		if err == nil {
+			bt.bytes += len(out)
+		}
+		bt.records = append(bt.records, r)
+	}
+	if (b.MaxCount > 0 && len(bt.records) >= b.MaxCount) || (b.MaxBytes > 0 && bt.bytes >= b.MaxBytes) {
+		return b.send(record.Index)
+	}
+	return nil
+}
+
+// Flush sends every pending batch and flushes the sink
+func (b *Batcher) Flush() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.flush()
+}
+
+// Close sends every pending batch and closes the sink
+func (b *Batcher) Close() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	err := b.flush()
+	if cerr := b.sink.Close(); cerr != nil {
+		err = cerr
+	}
+	return err
+}
+
+// Depth passes through the depth of any spool behind the batcher
+func (b *Batcher) Depth() int {
+	return Depth(b.sink)
+}
+
+// flush sends all batches, callers hold the lock
+func (b *Batcher) flush() error {
+	var err error
+	for index := range b.batches {
+		if serr := b.send(index); serr != nil {
+			err = serr
+		}
+	}
+	if ferr := b.sink.Flush(); ferr != nil {
+		err = ferr
+	}
+	return err
+}
+
+// send writes one index's batch to the sink and forgets it, callers hold the lock
+func (b *Batcher) send(index string) error {
+	bt := b.batches[index]
+	delete(b.batches, index)
+	if bt.timer != nil {
+		bt.timer.Stop()
+	}
+	if len(bt.records) == 0 {
+		return nil
+	}
+	return b.sink.Write(definitions.ZincRecordV2{
+		Index:   index,
+		Records: bt.records,
+	})
+}
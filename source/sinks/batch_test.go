@@ -0,0 +1,83 @@
+package sinks
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+func TestBatcher(t *testing.T) {
+	sink := &flakySink{}
+	b := NewBatcher(sink, 3, 0, 0, nil)
+	record := func(index string) definitions.ZincRecordV2 {
+		return definitions.ZincRecordV2{
+			Index:   index,
+			Records: []map[string]interface{}{{"usage": 1.5}},
+		}
+	}
+	for i := 0; i < 4; i++ {
+		b.Write(record("cpu"))
+	}
+	b.Write(record("weather"))
+	if got := sink.got(); len(got) != 1 || sink.sizes[0] != 3 {
+		t.Fatalf("expected one batch of 3 records, got %v %v", got, sink.sizes)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.got(); len(got) != 3 {
+		t.Fatalf("expected close to flush the remaining batches, got %v", got)
+	}
+
+	// age alone is enough to send a batch
+	sink = &flakySink{}
+	var logged syncBuffer
+	b = NewBatcher(sink, 0, 0, 10*time.Millisecond, log.New(&logged, "", 0))
+	defer b.Close()
+	b.Write(record("cpu"))
+	waitFor(t, func() bool { return len(sink.got()) == 1 })
+
+	// and a batch it couldn't send is logged, there is no write to fail
+	sink.setDown(true)
+	b.Write(record("cpu"))
+	waitFor(t, func() bool { return strings.Contains(logged.String(), "batch for cpu sink is down") })
+}
+
+// syncBuffer is a buffer a logger can write to while a test reads it
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+func TestBatcherPassesHistoricalRecords(t *testing.T) {
+	sink := &flakySink{}
+	b := NewBatcher(sink, 10, 0, 0, nil)
+	defer b.Close()
+	b.Write(definitions.ZincRecordV2{Index: "spp", Records: []map[string]interface{}{{"price": 20.5}}})
+	b.Write(definitions.ZincRecordV2{
+		Index:   "spp",
+		Records: []map[string]interface{}{{"price": 19.5}},
+		Time:    time.Date(2022, 12, 14, 10, 15, 0, 0, time.UTC),
+	})
+	// the live record is still waiting on its batch
+	if got := sink.got(); len(got) != 1 || sink.sizes[0] != 1 {
+		t.Fatalf("expected only the historical record to go straight through, got %v %v", got, sink.sizes)
+	}
+}
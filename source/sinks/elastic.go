@@ -0,0 +1,224 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// ElasticSink writes records to an elasticsearch or opensearch `_bulk` endpoint
+type ElasticSink struct {
+	Uri      string
+	User     string
+	Password string
+	Retries  int
+	Backoff  time.Duration
+	Client   *http.Client
+}
+
+// bulkResponse is the part of the `_bulk` response we care about
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// NewElasticSink creates an elastic sink, the password is read from password_env
+// (ELASTIC_API_PWD by default) and only sent when a user or password is set
+func NewElasticSink(cfg *definitions.SinkConfig) *ElasticSink {
+	e := &ElasticSink{
+		Uri:     strings.TrimSuffix(cfg.Uri, "/"),
+		User:    cfg.User,
+		Retries: cfg.Retries,
+		Backoff: time.Second,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if !strings.HasSuffix(e.Uri, "/_bulk") {
+		e.Uri += "/_bulk"
+	}
+	if e.Retries < 1 {
+		e.Retries = 3
+	}
+	env := cfg.PasswordEnv
+	if env == "" {
+		env = "ELASTIC_API_PWD"
+	}
+	e.Password = os.Getenv(env)
+	return e
+}
+
+// Write indexes every document in the record into the monthly index. documents the
+// cluster rejects with a retryable status are resent, up to Retries times. whatever
+// still fails is returned as a PartialError so the spool only retries those documents.
+func (e *ElasticSink) Write(record definitions.ZincRecordV2) error {
+	// elastic only accepts lower case index names
+	index := strings.ToLower(MonthlyIndex(record.Index, record.At(time.Now())))
+	pending := record.Records
+	backoff := e.Backoff
+	var rejected []string
+	for attempt := 1; ; attempt++ {
+		res, err := e.bulk(index, pending)
+		if err != nil {
+			if attempt == 1 {
+				// nothing was indexed, the whole record can be retried
+				return err
+			}
+			return &PartialError{Remaining: definitions.ZincRecordV2{Index: record.Index, Records: pending}, Err: err}
+		}
+		rejected = append(rejected, res.rejected...)
+		if len(res.retry) == 0 || attempt >= e.Retries {
+			if len(res.retry) == 0 && len(rejected) == 0 {
+				return nil
+			}
+			err := fmt.Errorf("%v documents rejected by %v, %v still failing after %v attempts: %v",
+				len(rejected), index, len(res.retry), attempt, strings.Join(rejected, "; "))
+			return &PartialError{Remaining: definitions.ZincRecordV2{Index: record.Index, Records: res.retry}, Err: err}
+		}
+		pending = res.retry
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// bulkResult holds the documents of a `_bulk` request worth retrying and the reasons
+// for any that were rejected outright
+type bulkResult struct {
+	retry    []map[string]interface{}
+	rejected []string
+}
+
+// bulk sends one `_bulk` request
+func (e *ElasticSink) bulk(index string, docs []map[string]interface{}) (bulkResult, error) {
+	var result bulkResult
+	var body bytes.Buffer
+	action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index}})
+	if err != nil {
+		return result, err
+	}
+	for _, doc := range docs {
+		out, err := json.Marshal(doc)
+		if err != nil {
+			return result, err
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(out)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, e.Uri, &body)
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if e.User != "" || e.Password != "" {
+		req.SetBasicAuth(e.User, e.Password)
+	}
+	res, err := e.Client.Do(req)
+	if err != nil {
+		return result, fmt.Errorf("http client failure %w", err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return result, err
+	}
+	if retryable(res.StatusCode) {
+		result.retry = docs
+		return result, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return result, statusError(res.StatusCode, fmt.Errorf("got an unexpected status code %v from %v: %s", res.StatusCode, e.Uri, data))
+	}
+
+	var br bulkResponse
+	if err := json.Unmarshal(data, &br); err != nil {
+		return result, err
+	}
+	if !br.Errors {
+		return result, nil
+	}
+	if len(br.Items) != len(docs) {
+		return result, fmt.Errorf("bulk response has %v items for %v documents", len(br.Items), len(docs))
+	}
+	for i, item := range br.Items {
+		for _, r := range item {
+			switch {
+			case r.Status < 300:
+			case retryable(r.Status):
+				result.retry = append(result.retry, docs[i])
+			default:
+				reason := http.StatusText(r.Status)
+				if r.Error != nil {
+					reason = fmt.Sprintf("%v: %v", r.Error.Type, r.Error.Reason)
+				}
+				result.rejected = append(result.rejected, reason)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Flush is a no op, every write is sent immediately
+func (e *ElasticSink) Flush() error {
+	return nil
+}
+
+// Close is a no op
+func (e *ElasticSink) Close() error {
+	return nil
+}
+
+// retryable reports whether a status is worth trying again
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// PartialError is returned by sinks that delivered some of a record. Remaining holds
+// the documents that should be retried, it may be empty when the rest were rejected.
+type PartialError struct {
+	Remaining definitions.ZincRecordV2
+	Err       error
+}
+
+func (p *PartialError) Error() string {
+	return fmt.Sprintf("%v documents undelivered: %v", len(p.Remaining.Records), p.Err)
+}
+
+func (p *PartialError) Unwrap() error {
+	return p.Err
+}
+
+// PermanentError is returned by sinks for a record they will never take, a malformed body
+// say, so there is no point retrying it
+type PermanentError struct {
+	Err error
+}
+
+func (p *PermanentError) Error() string {
+	return p.Err.Error()
+}
+
+func (p *PermanentError) Unwrap() error {
+	return p.Err
+}
+
+// statusError marks the error for an unexpected status permanent, unless the status is
+// worth trying again
+func statusError(status int, err error) error {
+	if retryable(status) {
+		return err
+	}
+	return &PermanentError{Err: err}
+}
@@ -0,0 +1,102 @@
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// bulkStub answers `_bulk` requests with the item statuses handed to it, one slice per request
+func bulkStub(t *testing.T, responses [][]int) (*httptest.Server, *[][]string) {
+	var seen [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("unexpected request %v %v", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var docs []string
+		scanner := bufio.NewScanner(r.Body)
+		for i := 0; scanner.Scan(); i++ {
+			if i%2 == 0 {
+				var action map[string]map[string]string
+				json.Unmarshal(scanner.Bytes(), &action)
+				if idx := action["index"]["_index"]; idx != strings.ToLower(idx) || !strings.HasSuffix(idx, "-ercotspp") {
+					t.Errorf("bad index name %v", idx)
+				}
+				continue
+			}
+			docs = append(docs, scanner.Text())
+		}
+		seen = append(seen, docs)
+		statuses := responses[len(seen)-1]
+		if len(statuses) == 1 && statuses[0] >= 500 {
+			w.WriteHeader(statuses[0])
+			return
+		}
+		var items []string
+		hasErrors := false
+		for _, status := range statuses {
+			item := fmt.Sprintf(`{"index":{"status":%v}}`, status)
+			if status >= 300 {
+				hasErrors = true
+				item = fmt.Sprintf(`{"index":{"status":%v,"error":{"type":"mapper_parsing_exception","reason":"nope"}}}`, status)
+			}
+			items = append(items, item)
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":%v,"items":[%v]}`, hasErrors, strings.Join(items, ","))
+	}))
+	return srv, &seen
+}
+
+func TestElasticSink(t *testing.T) {
+	record := definitions.ZincRecordV2{
+		Index: "ErcotSPP",
+		Records: []map[string]interface{}{
+			{"HbHouston": 1},
+			{"HbHouston": 2},
+			{"HbHouston": 3},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		responses [][]int
+		requests  int
+		remaining int
+		partial   bool
+	}{
+		{name: "success", responses: [][]int{{201, 201, 201}}, requests: 1},
+		{name: "whole request retried", responses: [][]int{{503}, {201, 201, 201}}, requests: 2},
+		{name: "retry and reject", responses: [][]int{{201, 429, 400}, {201}}, requests: 2, partial: true},
+		{name: "retries exhausted", responses: [][]int{{201, 429, 201}, {429}, {429}}, requests: 3, remaining: 1, partial: true},
+	}
+	for _, tc := range tests {
+		srv, seen := bulkStub(t, tc.responses)
+		sink := NewElasticSink(&definitions.SinkConfig{Uri: srv.URL})
+		sink.Backoff = time.Millisecond
+		err := sink.Write(record)
+		srv.Close()
+
+		if len(*seen) != tc.requests {
+			t.Errorf("%v: expected %v requests, got %v", tc.name, tc.requests, len(*seen))
+		}
+		var partial *PartialError
+		if errors.As(err, &partial) != tc.partial {
+			t.Errorf("%v: unexpected error %v", tc.name, err)
+			continue
+		}
+		if !tc.partial && err != nil {
+			t.Errorf("%v: unexpected error %v", tc.name, err)
+		}
+		if tc.partial && len(partial.Remaining.Records) != tc.remaining {
+			t.Errorf("%v: expected %v remaining documents, got %v", tc.name, tc.remaining, len(partial.Remaining.Records))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package sinks
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// how many records may wait on a single sink before new ones are dropped for it
+const fanoutQueue = 256
+
+// ErrClosed is returned for records written to a sink after it was closed
+var ErrClosed = errors.New("sink is closed")
+
+// Fanout delivers every record to several sinks. each sink is fed from its own queue by
+// its own goroutine, so a slow or failing destination only ever holds up itself.
+type Fanout struct {
+	outputs []*output
+	report  func(string, error)
+	wg      sync.WaitGroup
+	// held for reading while queueing, closed is only set with it held for writing
+	mtx    sync.RWMutex
+	closed bool
+}
+
+type output struct {
+	name  string
+	sink  definitions.Sink
+	queue chan fanoutItem
+}
+
+// fanoutItem is either a record to write or, when flushed is set, a flush request
+type fanoutItem struct {
+	record  definitions.ZincRecordV2
+	flushed chan error
+}
+
+// NewFanout starts a goroutine for each of the named sinks
+func NewFanout(sinks map[string]definitions.Sink, report func(string, error)) *Fanout {
+	f := &Fanout{report: report}
+	for name, sink := range sinks {
+		o := &output{name: name, sink: sink, queue: make(chan fanoutItem, fanoutQueue)}
+		f.outputs = append(f.outputs, o)
+		f.wg.Add(1)
+		go f.run(o)
+	}
+	return f
+}
+
+// Write queues the record for every sink. a sink whose queue is full misses the record,
+// which is reported as a failure for that sink.
+func (f *Fanout) Write(record definitions.ZincRecordV2) error {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	if f.closed {
+		return ErrClosed
+	}
+	for _, o := range f.outputs {
+		select {
+		case o.queue <- fanoutItem{record: record}:
+		default:
+			if f.report != nil {
+				f.report(o.name, errors.New("queue is full, record dropped"))
+			}
+		}
+	}
+	return nil
+}
+
+// Flush waits for every sink to work through its queue and flush
+func (f *Fanout) Flush() error {
+	f.mtx.RLock()
+	if f.closed {
+		f.mtx.RUnlock()
+		return ErrClosed
+	}
+	var pending []chan error
+	for _, o := range f.outputs {
+		done := make(chan error, 1)
+		o.queue <- fanoutItem{flushed: done}
+		pending = append(pending, done)
+	}
+	f.mtx.RUnlock()
+	var err error
+	for _, done := range pending {
+		if ferr := <-done; ferr != nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// Close lets every sink drain its queue and then closes them
+func (f *Fanout) Close() error {
+	f.mtx.Lock()
+	if !f.closed {
+		f.closed = true
+		for _, o := range f.outputs {
+			close(o.queue)
+		}
+	}
+	f.mtx.Unlock()
+	f.wg.Wait()
+	var err error
+	for _, o := range f.outputs {
+		if cerr := o.sink.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Depth is everything queued for, or spooled behind, the sinks
+func (f *Fanout) Depth() int {
+	n := 0
+	for _, o := range f.outputs {
+		n += len(o.queue) + Depth(o.sink)
+	}
+	return n
+}
+
+func (f *Fanout) run(o *output) {
+	defer f.wg.Done()
+	for item := range o.queue {
+		if item.flushed != nil {
+			item.flushed <- o.sink.Flush()
+			continue
+		}
+		// failures are reported by the sink itself
+		_ = o.sink.Write(item.record)
+	}
+}
@@ -0,0 +1,53 @@
+package sinks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// stuckSink blocks every write until released
+type stuckSink struct {
+	flakySink
+	release chan struct{}
+}
+
+func (s *stuckSink) Write(record definitions.ZincRecordV2) error {
+	<-s.release
+	return s.flakySink.Write(record)
+}
+
+func TestFanout(t *testing.T) {
+	fast := &flakySink{}
+	slow := &stuckSink{release: make(chan struct{})}
+	f := NewFanout(map[string]definitions.Sink{"fast": fast, "slow": slow}, nil)
+	for i := 0; i < 3; i++ {
+		if err := f.Write(definitions.ZincRecordV2{Index: "rtsc"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the stuck sink doesn't hold up the other one
+	waitFor(t, func() bool { return len(fast.got()) == 3 })
+	if len(slow.got()) != 0 {
+		t.Errorf("stuck sink shouldn't have written anything")
+	}
+
+	close(slow.release)
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(slow.got()) != 3 {
+		t.Errorf("expected flush to wait for the slow sink, got %v", slow.got())
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a record arriving after close is turned away instead of panicking
+	if err := f.Write(definitions.ZincRecordV2{Index: "rtsc"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected a write after close to fail, got %v", err)
+	}
+	if err := f.Flush(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected a flush after close to fail, got %v", err)
+	}
+}
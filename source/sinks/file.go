@@ -0,0 +1,284 @@
+package sinks
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// FileSink writes records to newline delimited json or csv files, one set of files per
+// index under a directory named for the service. files are named <index>-<day>-<n> and a
+// new one is started every day and whenever the current one grows past MaxSize.
+type FileSink struct {
+	Dir     string
+	Format  string
+	MaxSize int64
+	Gzip    bool
+	// told about files that couldn't be rotated after the records were written
+	ErrorLog *log.Logger
+	mtx      sync.Mutex
+	files    map[string]*openFile
+}
+
+type openFile struct {
+	file   *os.File
+	path   string
+	day    string
+	size   int64
+	header []string
+}
+
+// NewFileSink creates a file sink writing under <path or data dir>/<service>
+func NewFileSink(cfg *definitions.SinkConfig, opts Options) (*FileSink, error) {
+	root := cfg.Path
+	if root == "" {
+		root = opts.DataDir
+	}
+	if root == "" {
+		return nil, fmt.Errorf("file sink for %v needs a path or a data_dir", opts.Service)
+	}
+	f := &FileSink{
+		Dir:      filepath.Join(root, fileName(opts.Service)),
+		Format:   cfg.Format,
+		MaxSize:  cfg.MaxSize,
+		Gzip:     cfg.Gzip,
+		ErrorLog: opts.ErrorLog,
+		files:    make(map[string]*openFile),
+	}
+	switch f.Format {
+	case "":
+		f.Format = "jsonl"
+	case "jsonl", "csv":
+	default:
+		return nil, fmt.Errorf("unknown file format %q", f.Format)
+	}
+	if err := os.MkdirAll(f.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends each record to the index's current file, rotating when needed
+func (f *FileSink) Write(record definitions.ZincRecordV2) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	out, err := f.current(record.Index, record.At(time.Now()))
+	if err != nil {
+		return err
+	}
+	var buf strings.Builder
+	if f.Format == "csv" {
+		err = f.writeCSV(&buf, out, record.Records)
+	} else {
+		err = writeJSONL(&buf, record.Records)
+	}
+	if err != nil {
+		return err
+	}
+	n, err := out.file.WriteString(buf.String())
+	out.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if f.MaxSize > 0 && out.size >= f.MaxSize {
+		// the records are in, failing now would have them written again
+		if err := f.rotate(record.Index); err != nil {
+			f.logError(err)
+		}
+	}
+	return nil
+}
+
+// Flush syncs every open file to disk
+func (f *FileSink) Flush() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	var err error
+	for _, out := range f.files {
+		if serr := out.file.Sync(); serr != nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// Close closes every open file. they are left uncompressed so a restart on the same day
+// carries on appending to them.
+func (f *FileSink) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	var err error
+	for index, out := range f.files {
+		if cerr := out.file.Close(); cerr != nil {
+			err = cerr
+		}
+		delete(f.files, index)
+	}
+	return err
+}
+
+// current returns the file the index should be written to at time now
+func (f *FileSink) current(index string, now time.Time) (*openFile, error) {
+	day := now.Format("20060102")
+	if out, ok := f.files[index]; ok {
+		if out.day == day {
+			return out, nil
+		}
+		if err := f.rotate(index); err != nil {
+			return nil, err
+		}
+	}
+
+	// carry on with the newest file for the day unless it is compressed or full
+	seq := 0
+	matches, _ := filepath.Glob(filepath.Join(f.Dir, fmt.Sprintf("%v-%v-*.%v*", fileName(index), day, f.Format)))
+	for _, m := range matches {
+		name := strings.TrimSuffix(filepath.Base(m), ".gz")
+		name = strings.TrimSuffix(name, "."+f.Format)
+		n, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(m); strings.HasSuffix(m, ".gz") || (err == nil && f.MaxSize > 0 && info.Size() >= f.MaxSize) {
+			n++
+		}
+		if n > seq {
+			seq = n
+		}
+	}
+	return f.open(index, day, seq)
+}
+
+// open opens (or creates) the numbered file for an index and day
+func (f *FileSink) open(index, day string, seq int) (*openFile, error) {
+	path := filepath.Join(f.Dir, fmt.Sprintf("%v-%v-%v.%v", fileName(index), day, seq, f.Format))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	out := &openFile{file: file, path: path, day: day, size: info.Size()}
+	if f.Format == "csv" && out.size > 0 {
+		// pick the header back up so appended rows line up with it
+		header, err := csv.NewReader(bufio.NewReader(file)).Read()
+		if err != nil && err != io.EOF {
+			file.Close()
+			return nil, err
+		}
+		out.header = header
+	}
+	f.files[index] = out
+	return out, nil
+}
+
+// rotate closes the index's current file, compressing it if configured, and forgets it.
+// the next write opens the following file. a file that can't be compressed is left as is
+func (f *FileSink) rotate(index string) error {
+	out := f.files[index]
+	delete(f.files, index)
+	if err := out.file.Close(); err != nil {
+		return err
+	}
+	if f.Gzip {
+		if err := gzipFile(out.path); err != nil {
+			f.logError(err)
+		}
+	}
+	return nil
+}
+
+func (f *FileSink) logError(err error) {
+	if f.ErrorLog != nil {
+		f.ErrorLog.Println("file sink:", err)
+	}
+}
+
+// writeCSV writes rows for the records, the header is set by the first record written
+// to a file, later fields that are not in it are dropped
+func (f *FileSink) writeCSV(w io.Writer, out *openFile, records []map[string]interface{}) error {
+	cw := csv.NewWriter(w)
+	for _, r := range records {
+		flat := make(map[string]interface{})
+		Flatten("", r, flat)
+		if out.header == nil {
+			for key := range flat {
+				out.header = append(out.header, key)
+			}
+			sort.Strings(out.header)
+			if err := cw.Write(out.header); err != nil {
+				return err
+			}
+		}
+		row := make([]string, len(out.header))
+		for i, key := range out.header {
+			switch v := flat[key].(type) {
+			case nil:
+			case string:
+				row[i] = v
+			case float64:
+				row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				b, _ := json.Marshal(v)
+				row[i] = string(b)
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSONL(w io.Writer, records []map[string]interface{}) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gzipFile compresses path to path.gz and removes the original
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
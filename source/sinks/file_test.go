@@ -0,0 +1,168 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// fileSink writes under a temp dir, dir is where the service's files end up
+func fileSink(t *testing.T, cfg *definitions.SinkConfig) (*FileSink, string) {
+	t.Helper()
+	cfg.Path = t.TempDir()
+	f, err := NewFileSink(cfg, Options{Service: "grid monitor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f, f.Dir
+}
+
+// readFile reads path, uncompressing it if it ends in .gz
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func record(at time.Time, fields map[string]interface{}) definitions.ZincRecordV2 {
+	return definitions.ZincRecordV2{Index: "rtsc", Records: []map[string]interface{}{fields}, Time: at}
+}
+
+func TestFileSinkRotatesBySize(t *testing.T) {
+	f, dir := fileSink(t, &definitions.SinkConfig{Type: "file", MaxSize: 1})
+	day := time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if err := f.Write(record(day, map[string]interface{}{"load": i})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every write fills a file, so each gets its own
+	got := files(t, dir)
+	if strings.Join(got, " ") != "rtsc-20221214-0.jsonl rtsc-20221214-1.jsonl" {
+		t.Fatalf("expected a file per write, got %v", got)
+	}
+	if out := readFile(t, filepath.Join(dir, got[1])); out != "{\"load\":1}\n" {
+		t.Errorf("expected the second record in the second file, got %q", out)
+	}
+}
+
+func TestFileSinkRotatesByDay(t *testing.T) {
+	f, dir := fileSink(t, &definitions.SinkConfig{Type: "file", Gzip: true})
+	day := time.Date(2022, 12, 14, 23, 59, 0, 0, time.UTC)
+	for _, at := range []time.Time{day, day, day.Add(2 * time.Minute)} {
+		if err := f.Write(record(at, map[string]interface{}{"day": at.Day()})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the day that ended is compressed, the new one is still being written
+	got := files(t, dir)
+	if strings.Join(got, " ") != "rtsc-20221214-0.jsonl.gz rtsc-20221215-0.jsonl" {
+		t.Fatalf("expected yesterday compressed and today open, got %v", got)
+	}
+	if out := readFile(t, filepath.Join(dir, got[0])); out != "{\"day\":14}\n{\"day\":14}\n" {
+		t.Errorf("expected both of yesterday's records, got %q", out)
+	}
+
+	// a restart on the same day carries on with the open file
+	f.Close()
+	f, err := NewFileSink(&definitions.SinkConfig{Type: "file", Path: filepath.Dir(dir), Gzip: true}, Options{Service: "grid monitor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Write(record(day.Add(3*time.Minute), map[string]interface{}{"day": 15})); err != nil {
+		t.Fatal(err)
+	}
+	if out := readFile(t, filepath.Join(dir, got[1])); out != "{\"day\":15}\n{\"day\":15}\n" {
+		t.Errorf("expected today's records in one file, got %q", out)
+	}
+}
+
+func TestFileSinkCSVHeader(t *testing.T) {
+	f, dir := fileSink(t, &definitions.SinkConfig{Type: "file", Format: "csv"})
+	day := time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC)
+	if err := f.Write(record(day, map[string]interface{}{"zone": "houston", "load": 61.5})); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// appending after a restart picks the header back up instead of writing another
+	f, err := NewFileSink(&definitions.SinkConfig{Type: "file", Format: "csv", Path: filepath.Dir(dir)}, Options{Service: "grid monitor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Write(record(day, map[string]interface{}{"load": 58, "zone": "austin", "extra": true})); err != nil {
+		t.Fatal(err)
+	}
+	out := readFile(t, filepath.Join(dir, "rtsc-20221214-0.csv"))
+	if out != "load,zone\n61.5,houston\n58,austin\n" {
+		t.Errorf("expected one header and both rows, got %q", out)
+	}
+}
+
+func TestFileSinkRotateFailure(t *testing.T) {
+	f, dir := fileSink(t, &definitions.SinkConfig{Type: "file", Gzip: true})
+	var logged bytes.Buffer
+	f.ErrorLog = log.New(&logged, "", 0)
+	day := time.Date(2022, 12, 14, 10, 0, 0, 0, time.UTC)
+	if err := f.Write(record(day, map[string]interface{}{"load": 1})); err != nil {
+		t.Fatal(err)
+	}
+	// the file goes missing, so filling it can't compress it
+	if err := os.Remove(filepath.Join(dir, "rtsc-20221214-0.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	f.MaxSize = 1
+	if err := f.Write(record(day, map[string]interface{}{"load": 2})); err != nil {
+		t.Errorf("expected the written record to succeed, got %v", err)
+	}
+	if !strings.Contains(logged.String(), "file sink:") {
+		t.Errorf("expected the failed rotation to be logged, got %q", logged.String())
+	}
+	// the next record starts the following file
+	if err := f.Write(record(day, map[string]interface{}{"load": 3})); err != nil {
+		t.Fatal(err)
+	}
+	if got := files(t, dir); len(got) != 1 || got[0] != "rtsc-20221214-0.jsonl.gz" {
+		t.Errorf("expected the next record compressed in a new file, got %v", got)
+	}
+}
@@ -0,0 +1,235 @@
+package sinks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// InfluxSink converts records to line protocol and posts them to an influxdb v2
+// `/api/v2/write` endpoint
+type InfluxSink struct {
+	Uri         string
+	Token       string
+	Measurement string
+	Tags        []string
+	Fields      []string
+	TimeField   string
+	Precision   string
+	Client      *http.Client
+}
+
+var precisions = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+// NewInfluxSink creates an influx sink, the api token is read from token_env (INFLUX_TOKEN
+// by default)
+func NewInfluxSink(cfg *definitions.SinkConfig) (*InfluxSink, error) {
+	i := &InfluxSink{
+		Measurement: cfg.Measurement,
+		Tags:        cfg.Tags,
+		Fields:      cfg.Fields,
+		TimeField:   cfg.TimeField,
+		Precision:   cfg.Precision,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+	}
+	if i.Precision == "" {
+		i.Precision = "ns"
+	}
+	if _, ok := precisions[i.Precision]; !ok {
+		return nil, fmt.Errorf("unknown influx precision %q", i.Precision)
+	}
+	q := url.Values{}
+	q.Set("org", cfg.Org)
+	q.Set("bucket", cfg.Bucket)
+	q.Set("precision", i.Precision)
+	i.Uri = fmt.Sprintf("%v/api/v2/write?%v", strings.TrimSuffix(cfg.Uri, "/"), q.Encode())
+	env := cfg.TokenEnv
+	if env == "" {
+		env = "INFLUX_TOKEN"
+	}
+	i.Token = os.Getenv(env)
+	return i, nil
+}
+
+// Write posts one line per record, records without any fields are skipped
+func (i *InfluxSink) Write(record definitions.ZincRecordV2) error {
+	var body bytes.Buffer
+	now := record.At(time.Now())
+	for _, r := range record.Records {
+		line := i.Line(record.Index, r, now)
+		if line == "" {
+			continue
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, i.Uri, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Authorization", "Token "+i.Token)
+	res, err := i.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http client failure %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(res.Body)
+		return statusError(res.StatusCode, fmt.Errorf("got an unexpected status code %v from influx: %s", res.StatusCode, msg))
+	}
+	return nil
+}
+
+// Line renders one record as line protocol. nested objects are flattened with dots, so
+// weather's temperature is `current.temp_c`. tags are taken from the configured keys, fields
+// from the configured keys or, when none are configured, every number and bool that is
+// not a tag. the timestamp comes from the time field and falls back to now.
+func (i *InfluxSink) Line(index string, record map[string]interface{}, now time.Time) string {
+	flat := make(map[string]interface{})
+	Flatten("", record, flat)
+
+	measurement := i.Measurement
+	if measurement == "" {
+		measurement = index
+	}
+	var line strings.Builder
+	line.WriteString(escape(measurement, ", "))
+
+	isTag := make(map[string]bool)
+	tags := append([]string{}, i.Tags...)
+	sort.Strings(tags)
+	for _, key := range tags {
+		isTag[key] = true
+		val, ok := flat[key]
+		if !ok || fmt.Sprint(val) == "" {
+			continue
+		}
+		fmt.Fprintf(&line, ",%v=%v", escape(key, ",= "), escape(fmt.Sprint(val), ",= "))
+	}
+
+	keys := append([]string{}, i.Fields...)
+	if len(keys) == 0 {
+		for key := range flat {
+			if !isTag[key] && key != i.TimeField {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	var fields []string
+	for _, key := range keys {
+		val, ok := flat[key]
+		if !ok {
+			continue
+		}
+		var out string
+		switch v := val.(type) {
+		case float64:
+			out = strconv.FormatFloat(v, 'f', -1, 64)
+		case int, int64:
+			out = fmt.Sprintf("%vi", v)
+		case bool:
+			out = strconv.FormatBool(v)
+		case string:
+			// strings are only fields when asked for
+			if len(i.Fields) == 0 {
+				continue
+			}
+			out = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		default:
+			continue
+		}
+		fields = append(fields, escape(key, ",= ")+"="+out)
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	line.WriteByte(' ')
+	line.WriteString(strings.Join(fields, ","))
+
+	ts := now
+	if t, ok := timestamp(flat[i.TimeField]); ok {
+		ts = t
+	}
+	fmt.Fprintf(&line, " %v", ts.UnixNano()/int64(precisions[i.Precision]))
+	return line.String()
+}
+
+// Flush is a no op, every write is sent immediately
+func (i *InfluxSink) Flush() error {
+	return nil
+}
+
+// Close is a no op
+func (i *InfluxSink) Close() error {
+	return nil
+}
+
+// Flatten copies nested maps into out with dot separated keys
+func Flatten(prefix string, in map[string]interface{}, out map[string]interface{}) {
+	for k, v := range in {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			Flatten(k, nested, out)
+			continue
+		}
+		out[k] = v
+	}
+}
+
+// escape backslash escapes the given characters
+func escape(s, chars string) string {
+	var out strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			out.WriteByte('\\')
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// timestamp reads a time out of a record value, either an RFC3339 string or a unix
+// epoch in seconds, milliseconds, microseconds or nanoseconds
+func timestamp(val interface{}) (time.Time, bool) {
+	switch v := val.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case float64:
+		n := int64(v)
+		switch {
+		case n <= 0:
+			return time.Time{}, false
+		case n < 1e11:
+			return time.Unix(n, 0), true
+		case n < 1e14:
+			return time.UnixMilli(n), true
+		case n < 1e17:
+			return time.UnixMicro(n), true
+		default:
+			return time.Unix(0, n), true
+		}
+	}
+	return time.Time{}, false
+}
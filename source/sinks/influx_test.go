@@ -0,0 +1,49 @@
+package sinks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInfluxLine(t *testing.T) {
+	now := time.Unix(1671000000, 0)
+	tests := []struct {
+		name     string
+		sink     InfluxSink
+		record   map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "numeric fields with a time field",
+			sink:     InfluxSink{TimeField: "time", Precision: "s"},
+			record:   map[string]interface{}{"freq": 60.01, "demand": 41234.0, "info": "ok", "time": "2022-12-14T10:00:00Z"},
+			expected: "ercotRTSC demand=41234,freq=60.01 1671012000",
+		},
+		{
+			name: "tags and nested keys are escaped",
+			sink: InfluxSink{Measurement: "weather now", Tags: []string{"location.name"}, Precision: "s"},
+			record: map[string]interface{}{
+				"location": map[string]interface{}{"name": "san antonio"},
+				"current":  map[string]interface{}{"temp_c": 12.5, "is_day": true},
+			},
+			expected: `weather\ now,location.name=san\ antonio current.is_day=true,current.temp_c=12.5 1671000000`,
+		},
+		{
+			name:     "configured fields include strings",
+			sink:     InfluxSink{Fields: []string{"Name", "Usage"}, TimeField: "epoch", Precision: "ms"},
+			record:   map[string]interface{}{"Name": `cpu "0"`, "Usage": 3.0, "epoch": 1671000000.0},
+			expected: `ercotRTSC Name="cpu \"0\"",Usage=3 1671000000000`,
+		},
+		{
+			name:     "no fields",
+			sink:     InfluxSink{Precision: "s"},
+			record:   map[string]interface{}{"info": "nothing numeric"},
+			expected: "",
+		},
+	}
+	for _, tc := range tests {
+		if got := tc.sink.Line("ercotRTSC", tc.record, now); got != tc.expected {
+			t.Errorf("%v: expected\n%v\ngot\n%v", tc.name, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package sinks
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// Options holds the runtime wide values a sink may need that are not part of its own config
+type Options struct {
+	Service    string
+	ZincUri    string
+	DataDir    string
+	SqlitePath string
+	ErrorLog   *log.Logger
+	// Report, when set, is told the outcome of every delivery attempt, err is nil on success
+	Report func(sink string, err error)
+	// spool is the file name of the sink's spool, the service name unless fanned out
+	spool string
+}
+
+// NewAll builds every configured sink, fanning out to them when there is more than one
+func NewAll(cfgs []*definitions.SinkConfig, opts Options) (definitions.Sink, error) {
+	if len(cfgs) < 2 {
+		var cfg *definitions.SinkConfig
+		if len(cfgs) == 1 {
+			cfg = cfgs[0]
+		}
+		return New(cfg, opts)
+	}
+	outputs := make(map[string]definitions.Sink)
+	for _, cfg := range cfgs {
+		name := Name(cfg)
+		if _, ok := outputs[name]; ok {
+			closeAll(outputs)
+			return nil, fmt.Errorf("%v has more than one sink named %v, give them a name", opts.Service, name)
+		}
+		o := opts
+		o.spool = opts.Service + "-" + name
+		sink, err := New(cfg, o)
+		if err != nil {
+			closeAll(outputs)
+			return nil, err
+		}
+		outputs[name] = sink
+	}
+	return NewFanout(outputs, opts.Report), nil
+}
+
+func closeAll(outputs map[string]definitions.Sink) {
+	for _, sink := range outputs {
+		sink.Close()
+	}
+}
+
+// New builds the sink described by cfg. a nil config gets a zinc sink pointed at the
+// runtime zinc uri, which is how records were delivered before sinks were configurable.
+// unless disabled, the sink is spooled under the data dir so failed deliveries are retried,
+// and when any batch threshold is set records are batched in front of the spool.
+func New(cfg *definitions.SinkConfig, opts Options) (definitions.Sink, error) {
+	if cfg == nil {
+		cfg = &definitions.SinkConfig{Type: "zinc"}
+	}
+	var sink definitions.Sink
+	switch cfg.Type {
+	case "", "zinc":
+		sink = NewZincSink(cfg, opts)
+	case "elasticsearch", "opensearch":
+		sink = NewElasticSink(cfg)
+	case "file":
+		file, err := NewFileSink(cfg, opts)
+		if err != nil {
+			return nil, err
+		}
+		sink = file
+	case "sqlite":
+		path := cfg.Path
+		if path == "" {
+			path = opts.SqlitePath
+		}
+		db, err := NewSQLiteSink(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		sink = db
+	case "influx":
+		influx, err := NewInfluxSink(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sink = influx
+	case "webhook":
+		webhook, err := NewWebhookSink(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sink = webhook
+	default:
+		return nil, fmt.Errorf("unknown sink type %q for %v", cfg.Type, opts.Service)
+	}
+	if opts.Report != nil {
+		sink = &reported{Sink: sink, name: Name(cfg), report: opts.Report}
+	}
+	if opts.DataDir != "" && !cfg.DisableSpool {
+		name := opts.spool
+		if name == "" {
+			name = opts.Service
+		}
+		spool, err := NewSpool(sink, filepath.Join(opts.DataDir, "spool", fileName(name)+".jsonl"), opts.ErrorLog)
+		if err != nil {
+			return nil, err
+		}
+		sink = spool
+	}
+	if cfg.BatchCount > 0 || cfg.BatchBytes > 0 || cfg.BatchAge > 0 {
+		sink = NewBatcher(sink, cfg.BatchCount, cfg.BatchBytes, time.Duration(cfg.BatchAge)*time.Second, opts.ErrorLog)
+	}
+	return sink, nil
+}
+
+// Name is what a sink is called in counters and errors, its configured name or its type
+func Name(cfg *definitions.SinkConfig) string {
+	switch {
+	case cfg == nil:
+		return "zinc"
+	case cfg.Name != "":
+		return cfg.Name
+	case cfg.Type == "":
+		return "zinc"
+	}
+	return cfg.Type
+}
+
+// reported passes the outcome of every write on to a report func
+type reported struct {
+	definitions.Sink
+	name   string
+	report func(string, error)
+}
+
+func (r *reported) Write(record definitions.ZincRecordV2) error {
+	err := r.Sink.Write(record)
+	r.report(r.name, err)
+	return err
+}
+
+// fileName makes a service name safe to use as a file name
+func fileName(name string) string {
+	return strings.NewReplacer(" ", "_", "/", "_", string(filepath.Separator), "_").Replace(name)
+}
@@ -0,0 +1,365 @@
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// how long the spool waits before retrying a failed delivery, doubling up to the max
+var (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+	// a record still failing after this many retries, about eight hours of them at the
+	// max backoff, is given up on
+	maxAttempts = 100
+)
+
+// Spool sits in front of a sink. records the sink fails to take are appended to a file
+// and retried in order with an exponential backoff, new records queue behind them until
+// the spool is drained. the file is read back in on start so nothing is lost to a restart.
+// a record the sink rejects for good, or that runs out of attempts, is moved to a
+// <spool>.rejected.jsonl file beside it so it doesn't hold up the rest.
+type Spool struct {
+	sink     definitions.Sink
+	path     string
+	logger   *log.Logger
+	mtx      sync.Mutex
+	queue    []definitions.ZincRecordV2
+	file     *os.File
+	consumed int
+	wake     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+	// held across writes, so they stay in order without holding up the retries on mtx
+	wmtx sync.Mutex
+}
+
+// NewSpool wraps sink with a spool persisted at path. the delivered position is kept
+// beside it in path.offset
+func NewSpool(sink definitions.Sink, path string, logger *log.Logger) (*Spool, error) {
+	if logger == nil {
+		logger = log.Default()
+	}
+	s := &Spool{
+		sink:   sink,
+		path:   path,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	s.wg.Add(1)
+	go s.drain()
+	if len(s.queue) > 0 {
+		s.logger.Printf("spool %v has %v undelivered records", path, len(s.queue))
+		s.signal()
+	}
+	return s, nil
+}
+
+// Write delivers the record straight to the sink when nothing is waiting, otherwise
+// (or when the delivery fails) the record is spooled. an error is only returned if the
+// record could not be persisted.
+func (s *Spool) Write(record definitions.ZincRecordV2) error {
+	s.wmtx.Lock()
+	defer s.wmtx.Unlock()
+	s.mtx.Lock()
+	empty := len(s.queue) == 0
+	s.mtx.Unlock()
+	if empty {
+		err := s.sink.Write(record)
+		if err == nil {
+			return nil
+		}
+		var partial *PartialError
+		if errors.As(err, &partial) {
+			// only spool what is worth another attempt
+			record = partial.Remaining
+			if len(record.Records) == 0 {
+				s.logger.Println(err)
+				return nil
+			}
+		}
+		var permanent *PermanentError
+		if errors.As(err, &permanent) {
+			s.reject(record, err)
+			return nil
+		}
+		s.logger.Println("spooling record for", record.Index, err)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.persist(record); err != nil {
+		return err
+	}
+	s.queue = append(s.queue, record)
+	s.signal()
+	return nil
+}
+
+// Flush flushes the underlying sink, spooled records are left to the retry loop
+func (s *Spool) Flush() error {
+	return s.sink.Flush()
+}
+
+// Close stops retrying and closes the underlying sink. anything still spooled stays on
+// disk for the next start.
+func (s *Spool) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		s.wmtx.Lock()
+		defer s.wmtx.Unlock()
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		err = s.file.Close()
+		if cerr := s.sink.Close(); cerr != nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+// Depth is the number of records waiting to be delivered
+func (s *Spool) Depth() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.queue)
+}
+
+func (s *Spool) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drain retries the head of the queue until it is delivered, backing off on failure
+func (s *Spool) drain() {
+	defer s.wg.Done()
+	backoff := minBackoff
+	wait := backoff
+	attempts := 0
+	for {
+		s.mtx.Lock()
+		empty := len(s.queue) == 0
+		s.mtx.Unlock()
+		if empty {
+			select {
+			case <-s.wake:
+				wait = minBackoff
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-s.done:
+				return
+			}
+		}
+
+		s.mtx.Lock()
+		head := s.queue[0]
+		s.mtx.Unlock()
+		err := s.sink.Write(head)
+		var partial *PartialError
+		if errors.As(err, &partial) {
+			s.logger.Println(err)
+			if len(partial.Remaining.Records) > 0 {
+				// the rest of the head was delivered, on a restart the whole entry is
+				// read back so those documents may be sent twice
+				s.mtx.Lock()
+				s.queue[0] = partial.Remaining
+				s.mtx.Unlock()
+			} else {
+				err = nil
+			}
+		}
+		attempts++
+		var permanent *PermanentError
+		if err != nil && (errors.As(err, &permanent) || attempts >= maxAttempts) {
+			s.mtx.Lock()
+			head = s.queue[0]
+			s.mtx.Unlock()
+			s.reject(head, fmt.Errorf("after %v attempts: %w", attempts, err))
+			err = nil
+		}
+		if err != nil {
+			s.logger.Printf("spool retry failed for %v, next attempt in %v: %v", head.Index, backoff, err)
+			wait = backoff
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+		wait = 0
+		attempts = 0
+		s.mtx.Lock()
+		s.queue = s.queue[1:]
+		if err := s.advance(); err != nil {
+			s.logger.Println("couldnt record spool position", err)
+		}
+		s.mtx.Unlock()
+	}
+}
+
+// reject moves a record the sink won't take to the dead letter file, where it can be
+// looked at and replayed by hand
+func (s *Spool) reject(record definitions.ZincRecordV2, err error) {
+	path := strings.TrimSuffix(s.path, ".jsonl") + ".rejected.jsonl"
+	s.logger.Printf("giving up on a record for %v, it is kept in %v: %v", record.Index, path, err)
+	out, merr := json.Marshal(record)
+	if merr != nil {
+		s.logger.Println("couldnt keep rejected record", merr)
+		return
+	}
+	file, ferr := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if ferr != nil {
+		s.logger.Println("couldnt keep rejected record", ferr)
+		return
+	}
+	defer file.Close()
+	if _, werr := file.Write(append(out, '\n')); werr != nil {
+		s.logger.Println("couldnt keep rejected record", werr)
+	}
+}
+
+// persist appends a record to the spool file and syncs it
+func (s *Spool) persist(record definitions.ZincRecordV2) error {
+	out, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(out, '\n')); err != nil {
+		return fmt.Errorf("couldnt spool record: %w", err)
+	}
+	return s.file.Sync()
+}
+
+// advance moves the delivered position forward one record, once the queue is empty
+// the spool file is truncated instead. callers hold the lock.
+func (s *Spool) advance() error {
+	if len(s.queue) == 0 {
+		s.consumed = 0
+		if err := s.file.Truncate(0); err != nil {
+			return err
+		}
+		if err := os.Remove(s.path + ".offset"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	s.consumed++
+	return os.WriteFile(s.path+".offset", []byte(strconv.Itoa(s.consumed)), 0666)
+}
+
+// load reads any records left over from a previous run, skipping the ones already delivered
+func (s *Spool) load() error {
+	offset, err := os.ReadFile(s.path + ".offset")
+	if err == nil {
+		s.consumed, err = strconv.Atoi(strings.TrimSpace(string(offset)))
+		if err != nil {
+			return fmt.Errorf("bad spool offset for %v: %w", s.path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 0; scanner.Scan(); line++ {
+		if line < s.consumed || len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record definitions.ZincRecordV2
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// a torn final write from a crash, everything before it is still good
+			s.logger.Println("skipping unreadable spool entry", s.path, err)
+			continue
+		}
+		s.queue = append(s.queue, record)
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the spool file with only the queued records and drops the offset
+func (s *Spool) compact() error {
+	tmp := s.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, record := range s.queue {
+		out, err := json.Marshal(record)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.Write(append(out, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.consumed = 0
+	if err := os.Remove(s.path + ".offset"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Depth reports how many records are spooled behind a sink, zero if it has no spool
+func Depth(sink definitions.Sink) int {
+	if d, ok := sink.(interface{ Depth() int }); ok {
+		return d.Depth()
+	}
+	return 0
+}
@@ -0,0 +1,194 @@
+package sinks
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// flakySink fails every write while down is set and remembers what it was given
+type flakySink struct {
+	mtx     sync.Mutex
+	down    bool
+	written []string
+	sizes   []int
+}
+
+func (f *flakySink) Write(record definitions.ZincRecordV2) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.down {
+		return errors.New("sink is down")
+	}
+	f.written = append(f.written, record.Index)
+	f.sizes = append(f.sizes, len(record.Records))
+	return nil
+}
+
+func (f *flakySink) Flush() error { return nil }
+func (f *flakySink) Close() error { return nil }
+
+func (f *flakySink) setDown(down bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.down = down
+}
+
+func (f *flakySink) got() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]string{}, f.written...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
+
+func TestSpool(t *testing.T) {
+	minBackoff, maxBackoff = time.Millisecond, 10*time.Millisecond
+	path := filepath.Join(t.TempDir(), "spool", "svc.jsonl")
+	sink := &flakySink{down: true}
+
+	spool, err := NewSpool(sink, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, idx := range []string{"one", "two", "three"} {
+		if err := spool.Write(definitions.ZincRecordV2{Index: idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if spool.Depth() != 3 {
+		t.Errorf("expected 3 spooled records, got %v", spool.Depth())
+	}
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new spool on the same path picks up where the last one left off
+	sink.setDown(false)
+	spool, err = NewSpool(sink, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	if err := spool.Write(definitions.ZincRecordV2{Index: "four"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return spool.Depth() == 0 })
+
+	expected := []string{"one", "two", "three", "four"}
+	got := sink.got()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	}
+}
+
+// enteredSink tells when a write has reached it, then blocks it until released
+type enteredSink struct {
+	stuckSink
+	entered chan struct{}
+}
+
+func (s *enteredSink) Write(record definitions.ZincRecordV2) error {
+	s.entered <- struct{}{}
+	return s.stuckSink.Write(record)
+}
+
+func TestSpoolSlowSink(t *testing.T) {
+	sink := &enteredSink{stuckSink: stuckSink{release: make(chan struct{})}, entered: make(chan struct{}, 1)}
+	spool, err := NewSpool(sink, filepath.Join(t.TempDir(), "svc.jsonl"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	go spool.Write(definitions.ZincRecordV2{Index: "one"})
+	<-sink.entered
+	// a write stuck on the sink doesn't hold up anyone asking about the spool
+	depth := make(chan int)
+	go func() { depth <- spool.Depth() }()
+	select {
+	case n := <-depth:
+		if n != 0 {
+			t.Errorf("expected nothing spooled, got %v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("depth waited on the sink")
+	}
+	close(sink.release)
+	waitFor(t, func() bool { return len(sink.got()) == 1 })
+}
+
+// pickySink turns down records for the bad index for good, and the slow one until it
+// has been tried too often
+type pickySink struct {
+	flakySink
+}
+
+func (p *pickySink) Write(record definitions.ZincRecordV2) error {
+	switch record.Index {
+	case "bad":
+		return &PermanentError{Err: errors.New("malformed body")}
+	case "slow":
+		return errors.New("try again")
+	}
+	return p.flakySink.Write(record)
+}
+
+func TestSpoolRejects(t *testing.T) {
+	minBackoff, maxBackoff = time.Millisecond, 10*time.Millisecond
+	previous := maxAttempts
+	maxAttempts = 3
+	t.Cleanup(func() { maxAttempts = previous })
+	path := filepath.Join(t.TempDir(), "svc.jsonl")
+	sink := &pickySink{flakySink{down: true}}
+	spool, err := NewSpool(sink, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	for _, idx := range []string{"one", "bad", "slow", "two"} {
+		if err := spool.Write(definitions.ZincRecordV2{Index: idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// neither record that won't go through holds up the ones behind it
+	sink.setDown(false)
+	waitFor(t, func() bool { return spool.Depth() == 0 })
+	if got := sink.got(); len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Errorf("expected one and two delivered, got %v", got)
+	}
+	// with nothing spooled a rejected record goes straight to the dead letters
+	if err := spool.Write(definitions.ZincRecordV2{Index: "bad"}); err != nil {
+		t.Fatal(err)
+	}
+	if spool.Depth() != 0 {
+		t.Errorf("expected the rejected record not to be spooled, got %v", spool.Depth())
+	}
+	out, err := os.ReadFile(filepath.Join(filepath.Dir(path), "svc.rejected.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) != 3 ||
+		!strings.Contains(lines[0], `"bad"`) || !strings.Contains(lines[1], `"slow"`) || !strings.Contains(lines[2], `"bad"`) {
+		t.Errorf("expected bad, slow and bad in the dead letters, got %q", out)
+	}
+}
@@ -0,0 +1,204 @@
+package sinks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+	_ "modernc.org/sqlite"
+)
+
+const recordsSchema = `
+CREATE TABLE IF NOT EXISTS records (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	service TEXT NOT NULL,
+	idx TEXT NOT NULL,
+	ts INTEGER NOT NULL,
+	body TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS records_service_ts ON records (service, ts);
+`
+
+// databases are shared by every sink and query using the same file
+var (
+	dbMtx sync.Mutex
+	dbs   = make(map[string]*sql.DB)
+)
+
+// OpenDB opens (once per path) the sqlite database records are stored in
+func OpenDB(path string) (*sql.DB, error) {
+	dbMtx.Lock()
+	defer dbMtx.Unlock()
+	if db, ok := dbs[path]; ok {
+		return db, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%v?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path))
+	if err != nil {
+		return nil, err
+	}
+	// sqlite takes one writer at a time, so we do too
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(recordsSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	dbs[path] = db
+	return db, nil
+}
+
+// SQLiteSink stores each record as a json body alongside its service, index and time
+type SQLiteSink struct {
+	Service string
+	db      *sql.DB
+}
+
+// NewSQLiteSink creates a sqlite sink on the database at path
+func NewSQLiteSink(path string, opts Options) (*SQLiteSink, error) {
+	db, err := OpenDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteSink{Service: opts.Service, db: db}, nil
+}
+
+// Write inserts every record in a single transaction
+func (s *SQLiteSink) Write(record definitions.ZincRecordV2) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	ts := record.At(time.Now()).UnixMilli()
+	for _, r := range record.Records {
+		body, err := json.Marshal(r)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO records (service, idx, ts, body) VALUES (?, ?, ?, ?)`, s.Service, record.Index, ts, string(body))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Flush is a no op, every write is committed immediately
+func (s *SQLiteSink) Flush() error {
+	return nil
+}
+
+// Close is a no op, the database is shared and lives as long as records does
+func (s *SQLiteSink) Close() error {
+	return nil
+}
+
+// Query selects stored records. From and To are inclusive and ignored when zero,
+// Where predicates are all required to match.
+type Query struct {
+	Service string      `json:"service"`
+	Index   string      `json:"index"`
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	Where   []Predicate `json:"where"`
+	Limit   int         `json:"limit"`
+}
+
+// Predicate compares a field of the record body, nested fields are dot separated
+type Predicate struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// StoredRecord is a record as it comes back out of the database
+type StoredRecord struct {
+	Service string                 `json:"service"`
+	Index   string                 `json:"index"`
+	Time    time.Time              `json:"time"`
+	Record  map[string]interface{} `json:"record"`
+}
+
+var (
+	validField = regexp.MustCompile(`^[A-Za-z0-9_@]+(\.[A-Za-z0-9_@]+)*$`)
+	validOps   = map[string]string{"=": "=", "!=": "!=", ">": ">", ">=": ">=", "<": "<", "<=": "<=", "like": "LIKE"}
+)
+
+// QueryRecords runs q against db, newest records first
+func QueryRecords(db *sql.DB, q Query) ([]StoredRecord, error) {
+	var clauses []string
+	var args []interface{}
+	if q.Service != "" {
+		clauses = append(clauses, "service = ?")
+		args = append(args, q.Service)
+	}
+	if q.Index != "" {
+		clauses = append(clauses, "idx = ?")
+		args = append(args, q.Index)
+	}
+	if !q.From.IsZero() {
+		clauses = append(clauses, "ts >= ?")
+		args = append(args, q.From.UnixMilli())
+	}
+	if !q.To.IsZero() {
+		clauses = append(clauses, "ts <= ?")
+		args = append(args, q.To.UnixMilli())
+	}
+	for _, p := range q.Where {
+		op, ok := validOps[strings.ToLower(p.Op)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported operator %q", p.Op)
+		}
+		if !validField.MatchString(p.Field) {
+			return nil, fmt.Errorf("invalid field %q", p.Field)
+		}
+		switch p.Value.(type) {
+		case string, float64, bool:
+		default:
+			return nil, fmt.Errorf("field %v must be compared to a string, number or bool", p.Field)
+		}
+		clauses = append(clauses, fmt.Sprintf("json_extract(body, ?) %v ?", op))
+		args = append(args, "$."+p.Field, p.Value)
+	}
+	stmt := "SELECT service, idx, ts, body FROM records"
+	if len(clauses) > 0 {
+		stmt += " WHERE " + strings.Join(clauses, " AND ")
+	}
+	limit := q.Limit
+	if limit < 1 || limit > 10000 {
+		limit = 100
+	}
+	stmt += " ORDER BY ts DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []StoredRecord{}
+	for rows.Next() {
+		var r StoredRecord
+		var ts int64
+		var body string
+		if err := rows.Scan(&r.Service, &r.Index, &ts, &body); err != nil {
+			return nil, err
+		}
+		r.Time = time.UnixMilli(ts)
+		if err := json.Unmarshal([]byte(body), &r.Record); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
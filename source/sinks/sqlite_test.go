@@ -0,0 +1,62 @@
+package sinks
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+func TestSQLiteQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.db")
+	spp, err := NewSQLiteSink(path, Options{Service: "spp_monitor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weather, err := NewSQLiteSink(path, Options{Service: "weather_monitor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for _, price := range []float64{150, 250, 300} {
+		spp.Write(definitions.ZincRecordV2{
+			Index:   "ErcotSPP",
+			Records: []map[string]interface{}{{"LzHouston": price, "Date": "12/14/2022 1415"}},
+		})
+	}
+	weather.Write(definitions.ZincRecordV2{
+		Index: "verySpecialWeather",
+		Records: []map[string]interface{}{
+			{"location": map[string]interface{}{"name": "Houston"}, "current": map[string]interface{}{"temp_c": 10.5}},
+			{"location": map[string]interface{}{"name": "Austin"}, "current": map[string]interface{}{"temp_c": 8.0}},
+		},
+	})
+
+	db, _ := OpenDB(path)
+	tests := []struct {
+		name     string
+		query    Query
+		expected int
+		err      bool
+	}{
+		{name: "by service", query: Query{Service: "spp_monitor"}, expected: 3},
+		{name: "numeric predicate", query: Query{Service: "spp_monitor", Where: []Predicate{{"LzHouston", ">", 200.0}}}, expected: 2},
+		{name: "nested predicate", query: Query{Where: []Predicate{{"location.name", "=", "Austin"}}}, expected: 1},
+		{name: "time range", query: Query{From: start.Add(-time.Minute), To: start.Add(time.Minute)}, expected: 5},
+		{name: "time range excludes", query: Query{To: start.Add(-time.Minute)}, expected: 0},
+		{name: "limit", query: Query{Limit: 2}, expected: 2},
+		{name: "bad operator", query: Query{Where: []Predicate{{"LzHouston", "; drop", 1.0}}}, err: true},
+		{name: "bad field", query: Query{Where: []Predicate{{"a') OR 1=1 --", "=", 1.0}}}, err: true},
+	}
+	for _, tc := range tests {
+		results, err := QueryRecords(db, tc.query)
+		if (err != nil) != tc.err {
+			t.Errorf("%v: unexpected error %v", tc.name, err)
+			continue
+		}
+		if len(results) != tc.expected {
+			t.Errorf("%v: expected %v results, got %v", tc.name, tc.expected, len(results))
+		}
+	}
+}
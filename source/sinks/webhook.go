@@ -0,0 +1,163 @@
+package sinks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// SignatureHeader carries the hex hmac-sha256 of the request body when a secret is set
+const SignatureHeader = "X-Records-Signature"
+
+// WebhookSink sends every record to an http endpoint, rendering the body from a template
+type WebhookSink struct {
+	Uri      string
+	Method   string
+	Headers  map[string]string
+	Secret   []byte
+	Retries  int
+	Backoff  time.Duration
+	Client   *http.Client
+	template *template.Template
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+	"now": time.Now,
+	// when the record was collected, bound to each record as it is rendered
+	"time": time.Now,
+}
+
+// NewWebhookSink creates a webhook sink. the template is executed against the record, so
+// `{{.Index}}`, `{{json .Records}}` and `{{time}}`, when the record was collected, are
+// available, without one the record is sent as json. the signing secret is read from
+// secret_env.
+func NewWebhookSink(cfg *definitions.SinkConfig) (*WebhookSink, error) {
+	if cfg.Uri == "" {
+		return nil, fmt.Errorf("webhook sink needs a uri")
+	}
+	w := &WebhookSink{
+		Uri:     cfg.Uri,
+		Method:  strings.ToUpper(cfg.Method),
+		Headers: cfg.Headers,
+		Retries: cfg.Retries,
+		Backoff: time.Second,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if w.Method == "" {
+		w.Method = http.MethodPost
+	}
+	if w.Retries < 1 {
+		w.Retries = 3
+	}
+	if cfg.SecretEnv != "" {
+		w.Secret = []byte(os.Getenv(cfg.SecretEnv))
+	}
+	if cfg.Template != "" {
+		tmpl, err := template.New(cfg.Uri).Funcs(webhookFuncs).Parse(cfg.Template)
+		if err != nil {
+			return nil, err
+		}
+		w.template = tmpl
+	}
+	return w, nil
+}
+
+// Write renders the body and sends it, retrying network failures and retryable statuses
+func (w *WebhookSink) Write(record definitions.ZincRecordV2) error {
+	body, err := w.Render(record)
+	if err != nil {
+		return err
+	}
+	backoff := w.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.send(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.Retries {
+			err = fmt.Errorf("webhook %v failed after %v attempts: %w", w.Uri, attempt, err)
+			if !retry {
+				// the endpoint turned it down, sending it again won't change that
+				return &PermanentError{Err: err}
+			}
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Render produces the request body for a record
+func (w *WebhookSink) Render(record definitions.ZincRecordV2) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(record)
+	}
+	tmpl, err := w.template.Clone()
+	if err != nil {
+		return nil, err
+	}
+	at := record.At(time.Now())
+	tmpl.Funcs(template.FuncMap{"time": func() time.Time { return at }})
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, record); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Sign returns the hex encoded hmac-sha256 of body
+func (w *WebhookSink) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, w.Secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// send makes one request, reporting whether a failure is worth retrying
+func (w *WebhookSink) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(w.Method, w.Uri, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(w.Secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+w.Sign(body))
+	}
+	res, err := w.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("http client failure %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return retryable(res.StatusCode), fmt.Errorf("got an unexpected status code %v", res.StatusCode)
+	}
+	return false, nil
+}
+
+// Flush is a no op, every write is sent immediately
+func (w *WebhookSink) Flush() error {
+	return nil
+}
+
+// Close is a no op
+func (w *WebhookSink) Close() error {
+	return nil
+}
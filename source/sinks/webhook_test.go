@@ -0,0 +1,116 @@
+package sinks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+func TestWebhookSink(t *testing.T) {
+	t.Setenv("RECORDS_TEST_SECRET", "s3cret")
+	var mtx sync.Mutex
+	var body, signature, token string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mtx.Lock()
+		defer mtx.Unlock()
+		body, signature, token = string(b), r.Header.Get(SignatureHeader), r.Header.Get("X-Token")
+	}))
+	defer srv.Close()
+
+	w, err := NewWebhookSink(&definitions.SinkConfig{
+		Type:      "webhook",
+		Uri:       srv.URL,
+		Headers:   map[string]string{"X-Token": "abc"},
+		Template:  `{"text": "{{.Index}} sent {{len .Records}}", "records": {{json .Records}}}`,
+		SecretEnv: "RECORDS_TEST_SECRET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Write(definitions.ZincRecordV2{Index: "ErcotSPP", Records: []map[string]interface{}{{"LzHouston": 150.5}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if body != `{"text": "ErcotSPP sent 1", "records": [{"LzHouston":150.5}]}` {
+		t.Errorf("unexpected body %q", body)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(body))
+	if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != expected {
+		t.Errorf("expected the body signed as %v, got %v", expected, signature)
+	}
+	if token != "abc" {
+		t.Errorf("expected the configured header, got %q", token)
+	}
+
+	// a backfilled record renders with the time it was collected, not now
+	w, _ = NewWebhookSink(&definitions.SinkConfig{Type: "webhook", Uri: srv.URL, Template: `{{(time).Format "2006-01-02T15:04"}}`})
+	at := time.Date(2022, 12, 14, 14, 15, 0, 0, time.UTC)
+	if out, err := w.Render(definitions.ZincRecordV2{Index: "ErcotSPP", Time: at}); err != nil || string(out) != "2022-12-14T14:15" {
+		t.Errorf("expected the record's time, got %q and %v", out, err)
+	}
+
+	if _, err := NewWebhookSink(&definitions.SinkConfig{Type: "webhook", Uri: srv.URL, Template: "{{.Index"}); err == nil {
+		t.Error("expected a bad template to fail")
+	}
+}
+
+func TestWebhookSinkRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		fails    bool
+	}{
+		{"recovers", []int{http.StatusInternalServerError, http.StatusOK}, 2, false},
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}, 3, true},
+		{"rejected", []int{http.StatusBadRequest}, 1, true},
+		{"gone", []int{http.StatusNotFound}, 1, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var mtx sync.Mutex
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				status := http.StatusOK
+				if attempts < len(tc.statuses) {
+					status = tc.statuses[attempts]
+				}
+				attempts++
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+			w, err := NewWebhookSink(&definitions.SinkConfig{Type: "webhook", Uri: srv.URL, Retries: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Backoff = 0
+			err = w.Write(definitions.ZincRecordV2{Index: "rtsc"})
+			if tc.fails != (err != nil) {
+				t.Errorf("expected failure %v, got %v", tc.fails, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "unexpected status code") {
+				t.Errorf("expected the status in the error, got %v", err)
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			if attempts != tc.attempts {
+				t.Errorf("expected %v attempts, got %v", tc.attempts, attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// ZincSink posts records to a zinc `_bulkv2` endpoint
+type ZincSink struct {
+	Uri      string
+	User     string
+	Password string
+	Client   *http.Client
+}
+
+// NewZincSink creates a zinc sink, falling back to the runtime zinc uri and the
+// admin / ZINC_API_PWD credentials records has always used
+func NewZincSink(cfg *definitions.SinkConfig, opts Options) *ZincSink {
+	z := &ZincSink{
+		Uri:    cfg.Uri,
+		User:   cfg.User,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+	if z.Uri == "" {
+		z.Uri = opts.ZincUri
+	}
+	if z.User == "" {
+		z.User = "admin"
+	}
+	env := cfg.PasswordEnv
+	if env == "" {
+		env = "ZINC_API_PWD"
+	}
+	// ideally we'd be storing secrets in a secrets manager, this is for dev purposes
+	z.Password = os.Getenv(env)
+	return z
+}
+
+// Write sends the record to zinc, the index is prefixed with the year and month
+func (z *ZincSink) Write(record definitions.ZincRecordV2) error {
+	record.Index = MonthlyIndex(record.Index, record.At(time.Now()))
+	out, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, z.Uri, bytes.NewBuffer(out))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Basic "+basicAuth(z.User, z.Password))
+	res, err := z.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http client failure %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return statusError(res.StatusCode, fmt.Errorf("got an unexpected status code %v from %v", res.StatusCode, z.Uri))
+	}
+	return nil
+}
+
+// Flush is a no op, every write is sent immediately
+func (z *ZincSink) Flush() error {
+	return nil
+}
+
+// Close is a no op
+func (z *ZincSink) Close() error {
+	return nil
+}
+
+// MonthlyIndex names an index by year and month: 202212-IndexName
+func MonthlyIndex(index string, t time.Time) string {
+	return fmt.Sprintf("%v-%v", t.Format("200601"), index)
+}
+
+func basicAuth(username, password string) string {
+	auth := username + ":" + password
+	return base64.StdEncoding.EncodeToString([]byte(auth))
+}
@@ -0,0 +1,93 @@
+package sinks
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rexlx/records/source/definitions"
+)
+
+// zincStub records what each request to it carried and answers with status
+func zincStub(t *testing.T, status int) (*httptest.Server, func() (index, user, password string)) {
+	var mtx sync.Mutex
+	var index, user, password string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var record definitions.ZincRecordV2
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			t.Errorf("expected a json record, got %v", err)
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		index = record.Index
+		user, password, _ = r.BasicAuth()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() (string, string, string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return index, user, password
+	}
+}
+
+func TestZincSink(t *testing.T) {
+	t.Setenv("ZINC_API_PWD", "default")
+	t.Setenv("RECORDS_TEST_ZINC", "s3cret")
+	srv, seen := zincStub(t, http.StatusOK)
+
+	// with nothing configured it posts to the runtime zinc uri as admin
+	z := NewZincSink(&definitions.SinkConfig{Type: "zinc"}, Options{ZincUri: srv.URL})
+	at := time.Date(2021, 3, 5, 14, 15, 0, 0, time.UTC)
+	if err := z.Write(definitions.ZincRecordV2{Index: "ErcotSPP", Time: at}); err != nil {
+		t.Fatal(err)
+	}
+	// a backfilled record lands in the month it was collected in
+	if index, user, password := seen(); index != "202103-ErcotSPP" || user != "admin" || password != "default" {
+		t.Errorf("expected 202103-ErcotSPP as admin:default, got %v as %v:%v", index, user, password)
+	}
+
+	// a configured uri wins over the runtime one, and the password comes from password_env
+	z = NewZincSink(&definitions.SinkConfig{Type: "zinc", Uri: srv.URL, User: "records", PasswordEnv: "RECORDS_TEST_ZINC"}, Options{ZincUri: "http://127.0.0.1:1"})
+	before := time.Now()
+	if err := z.Write(definitions.ZincRecordV2{Index: "rtsc"}); err != nil {
+		t.Fatal(err)
+	}
+	index, user, password := seen()
+	if index != MonthlyIndex("rtsc", before) && index != MonthlyIndex("rtsc", time.Now()) {
+		t.Errorf("expected a live record in this month's index, got %v", index)
+	}
+	if user != "records" || password != "s3cret" {
+		t.Errorf("expected records:s3cret, got %v:%v", user, password)
+	}
+}
+
+func TestZincSinkStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		permanent bool
+	}{
+		{"rejected", http.StatusBadRequest, true},
+		{"unauthorized", http.StatusUnauthorized, true},
+		{"unavailable", http.StatusServiceUnavailable, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, _ := zincStub(t, tc.status)
+			z := NewZincSink(&definitions.SinkConfig{Type: "zinc", Uri: srv.URL}, Options{})
+			err := z.Write(definitions.ZincRecordV2{Index: "rtsc"})
+			if err == nil {
+				t.Fatalf("expected status %v to fail", tc.status)
+			}
+			var permanent *PermanentError
+			if errors.As(err, &permanent) != tc.permanent {
+				t.Errorf("expected permanent %v, got %v", tc.permanent, err)
+			}
+		})
+	}
+}